@@ -0,0 +1,152 @@
+package pfkey
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestIPv6Address(t *testing.T) {
+	src := Node{Addr: net.ParseIP("2001:db8::1"), Port: networkOrder16(500, nativeEndian)}
+	dst := Node{Addr: net.ParseIP("2001:db8::2")}
+
+	msg, err := BuildSADBGETSPI(1234, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := SADBAddress{Len: SADBADDRESS_LEN + 4, ExtType: SADB_EXT_ADDRESS_SRC, PrefixLen: 128}
+	if msg.Extensions.AddressSrc != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, msg.Extensions.AddressSrc)
+	}
+
+	buf := new(msgBuffer)
+	if err = msg.writeToBuffer(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// sadb_msg, followed by the source address
+	ext := buf.buf[SADBMSG_LEN*WORD_SIZE:][:int(expected.Len)*WORD_SIZE]
+	sAddr := ext[SADBADDRESS_LEN*WORD_SIZE:]
+	if family := nativeEndian.Uint16(sAddr); family != unix.AF_INET6 {
+		t.Errorf("Expected family %d but got %d instead", unix.AF_INET6, family)
+	}
+	if !bytes.Equal(sAddr[2:4], []byte{0x01, 0xf4}) {
+		t.Errorf("Expected port 500 in network order but got %x", sAddr[2:4])
+	}
+	if !bytes.Equal(sAddr[8:24], src.Addr) {
+		t.Errorf("Expected address %x but got %x instead", []byte(src.Addr), sAddr[8:24])
+	}
+	if !bytes.Equal(sAddr[SOCKADDRIN6_SIZE:], make([]byte, 4)) {
+		t.Errorf("Expected sockaddr_in6 to be padded with zeroes but got %x", sAddr[SOCKADDRIN6_SIZE:])
+	}
+
+	del, err := BuildSADBDELETE(1337, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := roundTripMsg(t, *del)
+	for _, n := range []struct {
+		expected Node
+		sAddr    sockAddr
+	}{
+		{src, received.Extensions.SockAddrSrc},
+		{dst, received.Extensions.SockAddrDst},
+	} {
+		node, err := n.sAddr.BuildNode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !node.Addr.Equal(n.expected.Addr) || node.Port != n.expected.Port {
+			t.Errorf("Expected %+v but got %+v instead", n.expected, node)
+		}
+	}
+
+	if received.Extensions.AddressDst.PrefixLen != 128 {
+		t.Errorf("Expected a prefix length of 128 but got %d instead", received.Extensions.AddressDst.PrefixLen)
+	}
+}
+
+func TestIPv4AddressPrefixLen(t *testing.T) {
+	msg := Msg{}
+	msg.SetAddressSrc(Node{Addr: net.IPv4(1, 2, 3, 4)})
+
+	expected := SADBAddress{Len: SADBADDRESS_LEN + SOCKADDRIN_LEN, ExtType: SADB_EXT_ADDRESS_SRC, PrefixLen: 32}
+	if msg.Extensions.AddressSrc != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, msg.Extensions.AddressSrc)
+	}
+}
+
+func TestMixedAddressFamilies(t *testing.T) {
+	v4 := Node{Addr: net.IPv4(1, 2, 3, 4)}
+	v6 := Node{Addr: net.ParseIP("2001:db8::1")}
+
+	if _, err := BuildSADBGETSPI(1, v4, v6); err == nil {
+		t.Error("Expected an error when building a SADB_GETSPI message with mixed address families")
+	}
+
+	if _, err := BuildSADBADD(1, 1337, v6, v4, make([]byte, 32)); err == nil {
+		t.Error("Expected an error when building a SADB_ADD message with mixed address families")
+	}
+
+	if _, err := BuildSADBDELETE(1337, v4, v6); err == nil {
+		t.Error("Expected an error when building a SADB_DELETE message with mixed address families")
+	}
+
+	msg := Msg{}
+	msg.SetAddressSrc(v4)
+	msg.SetAddressDst(v6)
+	if err := msg.writeToBuffer(new(msgBuffer)); err == nil {
+		t.Error("Expected an error when writing a message with mixed address families")
+	}
+
+	r := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 0)
+	r.SetTunnel(v4, v6)
+	if err := r.validate(); err == nil {
+		t.Error("Expected an error when the tunnel endpoints have mixed address families")
+	}
+}
+
+func TestIPv6Tunnel(t *testing.T) {
+	r := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 1)
+	r.SetTunnel(Node{Addr: net.ParseIP("2001:db8::1")}, Node{Addr: net.ParseIP("2001:db8::2")})
+
+	// Both sockaddr_in6 structures are packed together, 2*28 bytes is already 64-bit aligned
+	if r.Request.Len != SADBXIPSECREQUEST_LEN+2*SOCKADDRIN6_SIZE {
+		t.Errorf("Expected a request length of %d but got %d instead", SADBXIPSECREQUEST_LEN+2*SOCKADDRIN6_SIZE, r.Request.Len)
+	}
+
+	msg := Msg{Msg: SADBMsg{Version: PF_KEY_V2, Type: SADB_X_SPDADD}}
+	msg.SetXPolicy(SADBXPolicy{Type: IPSEC_POLICY_IPSEC, Dir: IPSEC_DIR_OUTBOUND}, []IPSecRequest{r})
+	msg.SetKMAddress(Node{Addr: net.ParseIP("2001:db8::1")}, Node{Addr: net.ParseIP("2001:db8::2")})
+	msg.setMsgLen()
+
+	if msg.Extensions.KMAddress.Len != SADBXKMADDRESS_LEN+2*SOCKADDRIN6_SIZE/WORD_SIZE {
+		t.Errorf("Unexpected KMAddress length %d", msg.Extensions.KMAddress.Len)
+	}
+
+	received := roundTripMsg(t, msg)
+
+	if len(received.Extensions.XPolicyRequests) != 1 {
+		t.Fatalf("Expected 1 IPsec request but got %d instead", len(received.Extensions.XPolicyRequests))
+	}
+
+	src, dst, err := received.Extensions.XPolicyRequests[0].Tunnel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !src.Addr.Equal(net.ParseIP("2001:db8::1")) || !dst.Addr.Equal(net.ParseIP("2001:db8::2")) {
+		t.Errorf("Unexpected tunnel endpoints %+v and %+v", src, dst)
+	}
+
+	local, err := received.Extensions.SockAddrKMLocal.BuildNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !local.Addr.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("Unexpected local KMAddress %+v", local)
+	}
+}
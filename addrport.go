@@ -0,0 +1,172 @@
+package pfkey
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// netip based variants of the address taking APIs. Unlike Node.Port, the ports in a
+// netip.AddrPort are in machine order, they're converted to network order when needed.
+
+// NodeFromAddrPort returns the Node for the address and port in ap.
+func NodeFromAddrPort(ap netip.AddrPort) Node {
+	n := Node{Port: networkOrder16(ap.Port(), nativeEndian)}
+	if ap.Addr().IsValid() {
+		n.Addr = net.IP(ap.Addr().Unmap().AsSlice())
+	}
+	return n
+}
+
+// AddrPort returns the address and port (in machine order) of this Node.
+func (n Node) AddrPort() netip.AddrPort {
+	addr, _ := netip.AddrFromSlice(n.Addr)
+	return netip.AddrPortFrom(addr.Unmap(), networkOrder16(n.Port, nativeEndian))
+}
+
+// BuildSADBGETSPIAddrPort builds a SADB_GETSPI message, see BuildSADBGETSPI.
+func BuildSADBGETSPIAddrPort(seq uint32, src netip.AddrPort, dst netip.AddrPort, opts ...SAOption) (Msg, error) {
+	return BuildSADBGETSPI(seq, NodeFromAddrPort(src), NodeFromAddrPort(dst), opts...)
+}
+
+// BuildSADBADDAddrPort builds a SADB_ADD message, see BuildSADBADD.
+func BuildSADBADDAddrPort(seq uint32, spi uint32, src netip.AddrPort, dst netip.AddrPort, encryptKey []byte, opts ...SAOption) (*Msg, error) {
+	return BuildSADBADD(seq, spi, NodeFromAddrPort(src), NodeFromAddrPort(dst), encryptKey, opts...)
+}
+
+// BuildSADBUPDATEAddrPort builds a SADB_UPDATE message, see BuildSADBUPDATE.
+func BuildSADBUPDATEAddrPort(seq uint32, spi uint32, src netip.AddrPort, dst netip.AddrPort, encryptKey []byte, opts ...SAOption) (*Msg, error) {
+	return BuildSADBUPDATE(seq, spi, NodeFromAddrPort(src), NodeFromAddrPort(dst), encryptKey, opts...)
+}
+
+// BuildSADBDELETEAddrPort builds a SADB_DELETE message, see BuildSADBDELETE.
+func BuildSADBDELETEAddrPort(spi uint32, src netip.AddrPort, dst netip.AddrPort, opts ...SAOption) (*Msg, error) {
+	return BuildSADBDELETE(spi, NodeFromAddrPort(src), NodeFromAddrPort(dst), opts...)
+}
+
+// SetAddressSrcAddrPort sets the value for the AddressSrc extension on this PFKEYMsg
+func (p *Msg) SetAddressSrcAddrPort(src netip.AddrPort) {
+	p.Extensions.AddressSrc, p.Extensions.SockAddrSrc = newAddress(SADB_EXT_ADDRESS_SRC, newSockAddr(src.Addr(), src.Port()))
+	p.Present.AddressSrc = true
+}
+
+// SetAddressDstAddrPort sets the value for the AddressDst extension on this PFKEYMsg
+func (p *Msg) SetAddressDstAddrPort(dst netip.AddrPort) {
+	p.Extensions.AddressDst, p.Extensions.SockAddrDst = newAddress(SADB_EXT_ADDRESS_DST, newSockAddr(dst.Addr(), dst.Port()))
+	p.Present.AddressDst = true
+}
+
+// SrcAddrPort returns the address and port of the AddressSrc extension of this PFKEYMsg.
+func (p *Msg) SrcAddrPort() (netip.AddrPort, error) {
+	if !p.HasAddressSrc() {
+		return netip.AddrPort{}, ErrExtensionNotPresent
+	}
+	return p.Extensions.SockAddrSrc.addrPort(), nil
+}
+
+// DstAddrPort returns the address and port of the AddressDst extension of this PFKEYMsg.
+func (p *Msg) DstAddrPort() (netip.AddrPort, error) {
+	if !p.HasAddressDst() {
+		return netip.AddrPort{}, ErrExtensionNotPresent
+	}
+	return p.Extensions.SockAddrDst.addrPort(), nil
+}
+
+// ProxyAddrPort returns the address and port of the AddressProxy extension of this PFKEYMsg.
+func (p *Msg) ProxyAddrPort() (netip.AddrPort, error) {
+	if !p.HasAddressProxy() {
+		return netip.AddrPort{}, ErrExtensionNotPresent
+	}
+	return p.Extensions.SockAddrProxy.addrPort(), nil
+}
+
+// SetAddressProxyAddrPort sets the value for the AddressProxy extension on this PFKEYMsg
+func (p *Msg) SetAddressProxyAddrPort(proxy netip.AddrPort) {
+	p.Extensions.AddressProxy, p.Extensions.SockAddrProxy = newAddress(SADB_EXT_ADDRESS_PROXY, newSockAddr(proxy.Addr(), proxy.Port()))
+	p.Present.AddressProxy = true
+}
+
+// SetNATTOAAddrPort sets the value for the NATTOA extension on this PFKEYMsg
+func (p *Msg) SetNATTOAAddrPort(oa netip.AddrPort) {
+	p.Extensions.NATTOA, p.Extensions.SockAddrNATTOA = newAddress(SADB_X_EXT_NAT_T_OA, newSockAddr(oa.Addr(), oa.Port()))
+	p.Present.NATTOA = true
+}
+
+// NATTOAAddrPort returns the address and port of the NATTOA extension of this PFKEYMsg.
+func (p *Msg) NATTOAAddrPort() (netip.AddrPort, error) {
+	if !p.HasNATTOA() {
+		return netip.AddrPort{}, ErrExtensionNotPresent
+	}
+	return p.Extensions.SockAddrNATTOA.addrPort(), nil
+}
+
+// WithNATTOAAddrPort adds the original address of the peer to the SA, see WithNATTOA.
+func WithNATTOAAddrPort(oa netip.AddrPort) SAOption {
+	return func(p *Msg) error {
+		p.SetNATTOAAddrPort(oa)
+		return nil
+	}
+}
+
+// SetKMAddressAddrPort sets the value for the KMAddress extension on this PFKEYMsg
+func (p *Msg) SetKMAddressAddrPort(local netip.AddrPort, remote netip.AddrPort) {
+	p.SetKMAddress(NodeFromAddrPort(local), NodeFromAddrPort(remote))
+}
+
+// KMAddressAddrPort returns the local and remote addresses of the KMAddress extension of this PFKEYMsg.
+func (p *Msg) KMAddressAddrPort() (netip.AddrPort, netip.AddrPort, error) {
+	if !p.HasKMAddress() {
+		return netip.AddrPort{}, netip.AddrPort{}, ErrExtensionNotPresent
+	}
+	return p.Extensions.SockAddrKMLocal.addrPort(), p.Extensions.SockAddrKMRemote.addrPort(), nil
+}
+
+// KMAddressFromAddrPort returns the KMAddress for the local and remote addresses of the key manager, to be used with BuildSADBXMIGRATE.
+func KMAddressFromAddrPort(local netip.AddrPort, remote netip.AddrPort) KMAddress {
+	return KMAddress{
+		Local:  NodeFromAddrPort(local),
+		Remote: NodeFromAddrPort(remote),
+	}
+}
+
+// AddrPorts returns the local and remote addresses of this KMAddress.
+func (k KMAddress) AddrPorts() (netip.AddrPort, netip.AddrPort) {
+	return k.Local.AddrPort(), k.Remote.AddrPort()
+}
+
+// SetTunnelAddrPort sets the endpoints of the tunnel for this IPSecRequest, see SetTunnel.
+func (r *IPSecRequest) SetTunnelAddrPort(src netip.AddrPort, dst netip.AddrPort) {
+	r.SetTunnel(NodeFromAddrPort(src), NodeFromAddrPort(dst))
+}
+
+// TunnelAddrPort returns the endpoints of the tunnel for this IPSecRequest.
+func (r *IPSecRequest) TunnelAddrPort() (netip.AddrPort, netip.AddrPort, error) {
+	if !r.HasTunnel() || r.SockAddrSrc == nil || r.SockAddrDst == nil {
+		return netip.AddrPort{}, netip.AddrPort{}, errors.New("IPsec request doesn't carry tunnel endpoints")
+	}
+	return r.SockAddrSrc.addrPort(), r.SockAddrDst.addrPort(), nil
+}
+
+// SrcAddrPort returns the source address and port of this Frame.
+func (f Frame) SrcAddrPort() (netip.AddrPort, error) {
+	return f.addrPort(SADB_EXT_ADDRESS_SRC)
+}
+
+// DstAddrPort returns the destination address and port of this Frame.
+func (f Frame) DstAddrPort() (netip.AddrPort, error) {
+	return f.addrPort(SADB_EXT_ADDRESS_DST)
+}
+
+// addrPort returns the address and port of the sadb_address extension of type extType in this Frame.
+func (f Frame) addrPort(extType uint16) (netip.AddrPort, error) {
+	ext, err := f.Extension(extType)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	_, sAddr, err := parseAddress(ext, f.order)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return sAddr.addrPort(), nil
+}
@@ -0,0 +1,202 @@
+package pfkey
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAddrPortBuilders(t *testing.T) {
+	for _, tc := range []struct {
+		src netip.AddrPort
+		dst netip.AddrPort
+	}{
+		{netip.MustParseAddrPort("1.2.3.4:500"), netip.MustParseAddrPort("5.6.7.8:4500")},
+		{netip.MustParseAddrPort("[2001:db8::1]:500"), netip.MustParseAddrPort("[2001:db8::2]:4500")},
+	} {
+		msg, err := BuildSADBDELETEAddrPort(1337, tc.src, tc.dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Same message as the one built from Nodes, whose ports are in network order
+		expected, err := BuildSADBDELETE(1337, NodeFromAddrPort(tc.src), NodeFromAddrPort(tc.dst))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = compareMessages(*expected, *msg); err != nil {
+			t.Error(err)
+		}
+
+		received := roundTripMsg(t, *msg)
+
+		src, err := received.SrcAddrPort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src != tc.src {
+			t.Errorf("Expected source %s but got %s instead", tc.src, src)
+		}
+
+		dst, err := received.DstAddrPort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dst != tc.dst {
+			t.Errorf("Expected destination %s but got %s instead", tc.dst, dst)
+		}
+	}
+
+	if _, err := BuildSADBGETSPIAddrPort(1, netip.MustParseAddrPort("1.2.3.4:0"), netip.MustParseAddrPort("[2001:db8::1]:0")); err == nil {
+		t.Error("Expected an error when building a SADB_GETSPI message with mixed address families")
+	}
+}
+
+func TestSetAddressAddrPort(t *testing.T) {
+	msg := Msg{}
+	msg.SetAddressSrcAddrPort(netip.MustParseAddrPort("10.0.0.1:500"))
+	msg.SetAddressDstAddrPort(netip.MustParseAddrPort("10.0.0.2:4500"))
+
+	expected := Msg{}
+	expected.SetAddressSrc(Node{Addr: net.IPv4(10, 0, 0, 1), Port: networkOrder16(500, nativeEndian)})
+	expected.SetAddressDst(Node{Addr: net.IPv4(10, 0, 0, 2), Port: networkOrder16(4500, nativeEndian)})
+
+	if err := compareMessages(expected, msg); err != nil {
+		t.Error(err)
+	}
+
+	buf := new(msgBuffer)
+	if err := msg.writeToBuffer(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// sadb_msg, sadb_address and then sin_family
+	port := buf.buf[SADBMSG_LEN*WORD_SIZE+SADBADDRESS_LEN*WORD_SIZE+2:][:2]
+	if !bytes.Equal(port, []byte{0x01, 0xf4}) {
+		t.Errorf("Expected port 500 in network order but got %x", port)
+	}
+
+	if _, err := msg.ProxyAddrPort(); err != ErrExtensionNotPresent {
+		t.Errorf("Expected %v but got %v instead", ErrExtensionNotPresent, err)
+	}
+}
+
+func TestNodeAddrPort(t *testing.T) {
+	for _, ap := range []netip.AddrPort{
+		netip.MustParseAddrPort("192.168.1.1:500"),
+		netip.MustParseAddrPort("[fe80::1]:4500"),
+	} {
+		if n := NodeFromAddrPort(ap); n.AddrPort() != ap {
+			t.Errorf("Expected %s but got %s instead", ap, n.AddrPort())
+		}
+	}
+
+	// A 4-byte net.IP used to make AddrAsArray panic
+	n := Node{Addr: net.IP{10, 0, 0, 1}}
+	if a := n.AddrAsArray(); a != [4]byte{10, 0, 0, 1} {
+		t.Errorf("Expected 10.0.0.1 but got %v", a)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		n.AddrAsArray()
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations in AddrAsArray but got %v", allocs)
+	}
+}
+
+func TestOptionalAddressesAddrPort(t *testing.T) {
+	proxy := netip.MustParseAddrPort("10.0.0.3:0")
+	oa := netip.MustParseAddrPort("192.168.1.10:4500")
+	local := netip.MustParseAddrPort("[2001:db8::1]:500")
+	remote := netip.MustParseAddrPort("[2001:db8::2]:500")
+
+	msg := Msg{}
+	msg.SetAddressProxyAddrPort(proxy)
+	msg.SetNATTOAAddrPort(oa)
+	msg.SetKMAddressAddrPort(local, remote)
+
+	expected := Msg{}
+	expected.SetAddressProxy(NodeFromAddrPort(proxy))
+	expected.SetNATTOA(NodeFromAddrPort(oa))
+	expected.SetKMAddress(NodeFromAddrPort(local), NodeFromAddrPort(remote))
+	if err := compareMessages(expected, msg); err != nil {
+		t.Error(err)
+	}
+
+	if got, err := msg.ProxyAddrPort(); err != nil || got != proxy {
+		t.Errorf("Expected proxy %s but got %s (%v) instead", proxy, got, err)
+	}
+	if got, err := msg.NATTOAAddrPort(); err != nil || got != oa {
+		t.Errorf("Expected NAT-T original address %s but got %s (%v) instead", oa, got, err)
+	}
+	gotLocal, gotRemote, err := msg.KMAddressAddrPort()
+	if err != nil || gotLocal != local || gotRemote != remote {
+		t.Errorf("Expected KM addresses %s and %s but got %s and %s (%v) instead", local, remote, gotLocal, gotRemote, err)
+	}
+
+	if l, r := KMAddressFromAddrPort(local, remote).AddrPorts(); l != local || r != remote {
+		t.Errorf("Expected KM addresses %s and %s but got %s and %s instead", local, remote, l, r)
+	}
+
+	if _, _, err = (&Msg{}).KMAddressAddrPort(); err != ErrExtensionNotPresent {
+		t.Errorf("Expected %v but got %v instead", ErrExtensionNotPresent, err)
+	}
+}
+
+func TestIPSecRequestTunnelAddrPort(t *testing.T) {
+	src := netip.MustParseAddrPort("1.2.3.4:0")
+	dst := netip.MustParseAddrPort("5.6.7.8:0")
+
+	r := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 0)
+	if _, _, err := r.TunnelAddrPort(); err == nil {
+		t.Error("Expected an error for a request without tunnel endpoints")
+	}
+
+	r.SetTunnelAddrPort(src, dst)
+
+	expected := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 0)
+	expected.SetTunnel(NodeFromAddrPort(src), NodeFromAddrPort(dst))
+	if r.Request != expected.Request {
+		t.Errorf("Expected request %+v but got %+v instead", expected.Request, r.Request)
+	}
+
+	gotSrc, gotDst, err := r.TunnelAddrPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotSrc != src || gotDst != dst {
+		t.Errorf("Expected tunnel %s -> %s but got %s -> %s instead", src, dst, gotSrc, gotDst)
+	}
+}
+
+func TestFrameAddrPort(t *testing.T) {
+	f, err := NewFrame(sadbDumpResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ParseMsg(sadbDumpResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := f.SrcAddrPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expectedSrc, _ := expected.SrcAddrPort(); src != expectedSrc {
+		t.Errorf("Expected source %s but got %s instead", expectedSrc, src)
+	}
+
+	dst, err := f.DstAddrPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expectedDst, _ := expected.DstAddrPort(); dst != expectedDst {
+		t.Errorf("Expected destination %s but got %s instead", expectedDst, dst)
+	}
+}
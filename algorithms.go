@@ -0,0 +1,115 @@
+package pfkey
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Algorithm describes an authentication or encryption algorithm that can be used in an SA.
+type Algorithm struct {
+	Name string
+	// ID is one of the SADB_*ALG_* constants
+	ID uint8
+	// Encrypt is true for encryption (including AEAD) algorithms and false for authentication ones
+	Encrypt bool
+	// KeyBits lists the valid key sizes in bits, sorted in ascending order. For algorithms that need a nonce or salt
+	// (such as AES-CTR or AES-GCM) it's appended to the key and included in its size.
+	KeyBits []int
+	// IVLen is the size in bytes of the IV carried in every packet
+	IVLen int
+	// ICVLen is the size in bytes of the integrity check value carried in every packet
+	ICVLen int
+	// SaltLen is the size in bytes of the salt (or nonce) included at the end of the key
+	SaltLen int
+	// AEAD algorithms provide both encryption and authentication, so they can't be combined with an authentication algorithm
+	AEAD bool
+}
+
+// ValidKeyBits returns true if bits is a valid key size for this Algorithm.
+func (a Algorithm) ValidKeyBits(bits int) bool {
+	for _, b := range a.KeyBits {
+		if b == bits {
+			return true
+		}
+	}
+	return false
+}
+
+// clone returns a copy of this Algorithm that doesn't share its KeyBits with the registry.
+func (a Algorithm) clone() Algorithm {
+	a.KeyBits = slices.Clone(a.KeyBits)
+	return a
+}
+
+// algorithms holds all the algorithms we know about, it's indexed through LookupAlgorithm.
+// The KeyBits of every entry must be sorted in ascending order, GenerateKeys relies on it to pick the largest size.
+var algorithms = []Algorithm{
+	{Name: "hmac-md5", ID: SADB_AALG_MD5HMAC, KeyBits: []int{128}, ICVLen: 12},
+	{Name: "hmac-sha1", ID: SADB_AALG_SHA1HMAC, KeyBits: []int{160}, ICVLen: 12},
+	{Name: "hmac-sha2-256", ID: SADB_X_AALG_SHA2_256HMAC, KeyBits: []int{256}, ICVLen: 16},
+	{Name: "hmac-sha2-384", ID: SADB_X_AALG_SHA2_384HMAC, KeyBits: []int{384}, ICVLen: 24},
+	{Name: "hmac-sha2-512", ID: SADB_X_AALG_SHA2_512HMAC, KeyBits: []int{512}, ICVLen: 32},
+	{Name: "hmac-ripemd160", ID: SADB_X_AALG_RIPEMD160HMAC, KeyBits: []int{160}, ICVLen: 12},
+	{Name: "aes-xcbc-mac", ID: SADB_X_AALG_AES_XCBC_MAC, KeyBits: []int{128}, ICVLen: 12},
+
+	{Name: "null", ID: SADB_EALG_NULL, Encrypt: true, KeyBits: []int{0}},
+	{Name: "3des-cbc", ID: SADB_EALG_3DESCBC, Encrypt: true, KeyBits: []int{192}, IVLen: 8},
+	{Name: "aes-cbc", ID: SADB_X_EALG_AESCBC, Encrypt: true, KeyBits: []int{128, 192, 256}, IVLen: 16},
+	{Name: "aes-ctr", ID: SADB_X_EALG_AESCTR, Encrypt: true, KeyBits: []int{160, 224, 288}, IVLen: 8, SaltLen: 4},
+	{Name: "camellia-cbc", ID: SADB_X_EALG_CAMELLIACBC, Encrypt: true, KeyBits: []int{128, 192, 256}, IVLen: 16},
+	{Name: "aes-ccm-8", ID: SADB_X_EALG_AES_CCM_ICV8, Encrypt: true, KeyBits: []int{152, 216, 280}, IVLen: 8, ICVLen: 8, SaltLen: 3, AEAD: true},
+	{Name: "aes-ccm-12", ID: SADB_X_EALG_AES_CCM_ICV12, Encrypt: true, KeyBits: []int{152, 216, 280}, IVLen: 8, ICVLen: 12, SaltLen: 3, AEAD: true},
+	{Name: "aes-ccm-16", ID: SADB_X_EALG_AES_CCM_ICV16, Encrypt: true, KeyBits: []int{152, 216, 280}, IVLen: 8, ICVLen: 16, SaltLen: 3, AEAD: true},
+	{Name: "aes-gcm-8", ID: SADB_X_EALG_AES_GCM_ICV8, Encrypt: true, KeyBits: []int{160, 224, 288}, IVLen: 8, ICVLen: 8, SaltLen: 4, AEAD: true},
+	{Name: "aes-gcm-12", ID: SADB_X_EALG_AES_GCM_ICV12, Encrypt: true, KeyBits: []int{160, 224, 288}, IVLen: 8, ICVLen: 12, SaltLen: 4, AEAD: true},
+	{Name: "aes-gcm-16", ID: SADB_X_EALG_AES_GCM_ICV16, Encrypt: true, KeyBits: []int{160, 224, 288}, IVLen: 8, ICVLen: 16, SaltLen: 4, AEAD: true},
+	{Name: "null-aes-gmac", ID: SADB_X_EALG_NULL_AES_GMAC, Encrypt: true, KeyBits: []int{160, 224, 288}, IVLen: 8, ICVLen: 16, SaltLen: 4, AEAD: true},
+}
+
+// LookupAlgorithm returns the Algorithm with the given name (for example "aes-cbc" or "hmac-sha2-256").
+func LookupAlgorithm(name string) (Algorithm, bool) {
+	for _, a := range algorithms {
+		if a.Name == name {
+			return a.clone(), true
+		}
+	}
+	return Algorithm{}, false
+}
+
+// lookupAlgorithmID returns the encryption (if encrypt is true) or authentication Algorithm with the given ID.
+func lookupAlgorithmID(id uint8, encrypt bool) (Algorithm, bool) {
+	for _, a := range algorithms {
+		if a.ID == id && a.Encrypt == encrypt {
+			return a, true
+		}
+	}
+	return Algorithm{}, false
+}
+
+// checkKey returns an error if key isn't valid for the encryption (if encrypt is true) or authentication algorithm id.
+// Algorithms we don't know about are left for the kernel to validate.
+func checkKey(id uint8, encrypt bool, key []byte) error {
+	a, ok := lookupAlgorithmID(id, encrypt)
+	if !ok {
+		return nil
+	}
+
+	if !a.ValidKeyBits(len(key) * 8) {
+		return fmt.Errorf("invalid key size of %d bits for %s, expected one of %v", len(key)*8, a.Name, a.KeyBits)
+	}
+	return nil
+}
+
+// AEADAlgorithm returns the AEAD algorithm of the given family ("aes-gcm", "aes-ccm" or "null-aes-gmac") with an ICV of icvLen bytes.
+// It returns an error if the family doesn't support that ICV length.
+func AEADAlgorithm(family string, icvLen int) (Algorithm, error) {
+	for _, a := range algorithms {
+		if !a.AEAD || a.ICVLen != icvLen {
+			continue
+		}
+		if a.Name == family || a.Name == fmt.Sprintf("%s-%d", family, icvLen) {
+			return a.clone(), nil
+		}
+	}
+	return Algorithm{}, fmt.Errorf("no %s AEAD algorithm with an ICV of %d bytes", family, icvLen)
+}
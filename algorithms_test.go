@@ -0,0 +1,130 @@
+package pfkey
+
+import (
+	"bytes"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestLookupAlgorithm(t *testing.T) {
+	cases := []struct {
+		name    string
+		id      uint8
+		encrypt bool
+		aead    bool
+	}{
+		{"aes-cbc", SADB_X_EALG_AESCBC, true, false},
+		{"aes-gcm-16", SADB_X_EALG_AES_GCM_ICV16, true, true},
+		{"hmac-sha2-256", SADB_X_AALG_SHA2_256HMAC, false, false},
+		{"null", SADB_EALG_NULL, true, false},
+	}
+
+	for _, c := range cases {
+		a, ok := LookupAlgorithm(c.name)
+		if !ok {
+			t.Errorf("Algorithm %q not found", c.name)
+			continue
+		}
+		if a.ID != c.id || a.Encrypt != c.encrypt || a.AEAD != c.aead {
+			t.Errorf("Unexpected algorithm for %q: %+v", c.name, a)
+		}
+	}
+
+	if _, ok := LookupAlgorithm("rot13"); ok {
+		t.Error("Expected an unknown algorithm not to be found")
+	}
+}
+
+// TestAlgorithmsMatchRegistration checks the key sizes in the registry against the ones the kernel advertised in the registration fixture.
+func TestAlgorithmsMatchRegistration(t *testing.T) {
+	registration := expectedMessages["registration_1"]
+
+	check := func(algs []SADBAlg, encrypt bool) {
+		for _, alg := range algs {
+			a, ok := lookupAlgorithmID(alg.ID, encrypt)
+			if !ok {
+				continue
+			}
+			for _, bits := range a.KeyBits {
+				if bits < int(alg.MinBits) || bits > int(alg.MaxBits) {
+					t.Errorf("Key size of %d bits for %s is outside of the kernel's %d-%d range", bits, a.Name, alg.MinBits, alg.MaxBits)
+				}
+			}
+		}
+	}
+
+	check(registration.Extensions.AuthAlgorithms, false)
+	check(registration.Extensions.EncryptAlgorithms, true)
+}
+
+func TestBuildSAKeyLength(t *testing.T) {
+	cfg := SAConfig{
+		Src:        netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst:        netip.MustParseAddrPort("10.0.0.2:0"),
+		EncryptAlg: SADB_X_EALG_AESCBC,
+		EncryptKey: bytes.Repeat([]byte{1}, 20),
+	}
+	if _, err := BuildSA(cfg); err == nil {
+		t.Error("Expected a 160 bit AES-CBC key to be rejected")
+	}
+
+	cfg.EncryptAlg = SADB_X_EALG_AESCTR
+	if _, err := BuildSA(cfg); err != nil {
+		t.Errorf("Expected a 160 bit AES-CTR key to be accepted: %v", err)
+	}
+
+	cfg.AuthAlg = SADB_X_AALG_SHA2_256HMAC
+	cfg.AuthKey = bytes.Repeat([]byte{2}, 20)
+	if _, err := BuildSA(cfg); err == nil {
+		t.Error("Expected a 160 bit HMAC-SHA2-256 key to be rejected")
+	}
+
+	// Algorithms missing from the registry are left for the kernel to validate
+	cfg.AuthAlg = 200
+	if _, err := BuildSA(cfg); err != nil {
+		t.Errorf("Expected a key for an unknown algorithm to be accepted: %v", err)
+	}
+}
+
+func TestAEADAlgorithm(t *testing.T) {
+	a, err := AEADAlgorithm("aes-gcm", 16)
+	if err != nil || a.ID != SADB_X_EALG_AES_GCM_ICV16 {
+		t.Errorf("Unexpected algorithm %+v for aes-gcm with a 16 byte ICV: %v", a, err)
+	}
+
+	a, err = AEADAlgorithm("null-aes-gmac", 16)
+	if err != nil || a.ID != SADB_X_EALG_NULL_AES_GMAC {
+		t.Errorf("Unexpected algorithm %+v for null-aes-gmac: %v", a, err)
+	}
+
+	if _, err = AEADAlgorithm("aes-ccm", 10); err == nil {
+		t.Error("Expected aes-ccm with a 10 byte ICV to be rejected")
+	}
+	if _, err = AEADAlgorithm("aes-cbc", 16); err == nil {
+		t.Error("Expected a non AEAD algorithm to be rejected")
+	}
+}
+
+func TestAlgorithmsKeyBitsSorted(t *testing.T) {
+	for _, a := range algorithms {
+		if !slices.IsSorted(a.KeyBits) {
+			t.Errorf("Key sizes for %s aren't sorted: %v", a.Name, a.KeyBits)
+		}
+	}
+}
+
+func TestLookupAlgorithmCopy(t *testing.T) {
+	a, _ := LookupAlgorithm("aes-cbc")
+	a.KeyBits[0] = 160
+
+	gcm, _ := AEADAlgorithm("aes-gcm", 16)
+	gcm.KeyBits[0] = 256
+
+	if err := checkKey(SADB_X_EALG_AESCBC, true, make([]byte, 20)); err == nil {
+		t.Error("Expected changes to a looked up Algorithm not to affect key validation")
+	}
+	if err := checkKey(SADB_X_EALG_AES_GCM_ICV16, true, make([]byte, 32)); err == nil {
+		t.Error("Expected changes to an AEAD Algorithm not to affect key validation")
+	}
+}
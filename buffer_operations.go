@@ -1,38 +1,85 @@
 package pfkey
 
 import (
-	"bytes"
 	"encoding/binary"
+	"math/bits"
 )
 
 // GetSPI converts the SPI stored in the SADBSA from network order
 // to machine order and returns it
 func (s *SADBSA) GetSPI() uint32 {
+	return networkOrder32(s.SPI, nativeEndian)
+}
 
-	spiBuf := new(bytes.Buffer)
-	var spi uint32
+// GetCPI returns the CPI of an IPComp SA, which is kept in the lower 16 bits of its SPI
+func (s *SADBSA) GetCPI() uint16 {
+	return uint16(s.GetSPI())
+}
 
-	binary.Write(spiBuf, binary.LittleEndian, s.SPI)
-	binary.Read(spiBuf, binary.BigEndian, &spi)
+// GetPort converts the port stored in the SADBXNATTPort from network order
+// to machine order and returns it
+func (s *SADBXNATTPort) GetPort() uint16 {
+	return networkOrder16(s.Port, nativeEndian)
+}
 
-	return spi
+// networkOrder16 converts v between a host using the given byte order and network order.
+// The conversion is its own inverse, so it's used in both directions.
+func networkOrder16(v uint16, order binary.ByteOrder) uint16 {
+	if order == binary.ByteOrder(binary.BigEndian) {
+		return v
+	}
+	return bits.ReverseBytes16(v)
 }
 
-// writeStruct will write an arbitrary object into the underlying buffer inside a msgBuffer
-func (b *msgBuffer) writeStruct(object interface{}) error {
-	// TODO: Pay attention at how many bytes we managed to write
-	msgBytes, err := getBytes(object)
-	if err != nil {
-		return err
+// networkOrder32 converts v between a host using the given byte order and network order.
+// The conversion is its own inverse, so it's used in both directions.
+func networkOrder32(v uint32, order binary.ByteOrder) uint32 {
+	if order == binary.ByteOrder(binary.BigEndian) {
+		return v
 	}
-	_, err = b.buf.Write(msgBytes)
+	return bits.ReverseBytes32(v)
+}
 
-	return err
+// grow extends the underlying buffer by n bytes and returns the newly added slice.
+func (b *msgBuffer) grow(n int) []byte {
+	l := len(b.buf)
+	b.buf = append(b.buf, make([]byte, n)...)
+	return b.buf[l:]
+}
+
+// writeStruct will write a PF_KEY structure into the underlying buffer inside a msgBuffer
+func (b *msgBuffer) writeStruct(object wireStruct) error {
+	order := b.order
+	if order == nil {
+		order = nativeEndian
+	}
+	object.marshal(b.grow(object.wireSize()), order)
+	return nil
 }
 
 // writeBytes writes an arbitrary slice of bytes into the underlying buffer
 func (b *msgBuffer) writeBytes(bts []byte) error {
-	// TODO: Look at how many bytes we actually wrote
-	_, err := b.buf.Write(bts)
+	b.buf = append(b.buf, bts...)
+	return nil
+}
+
+// align pads the underlying buffer with zeroes up to the next 64-bit boundary.
+func (b *msgBuffer) align() {
+	if rem := len(b.buf) % WORD_SIZE; rem != 0 {
+		b.grow(WORD_SIZE - rem)
+	}
+}
+
+// writePadded writes an arbitrary slice of bytes into the underlying buffer,
+// padding it with zeroes up to the next 64-bit boundary.
+func (b *msgBuffer) writePadded(bts []byte) error {
+	err := b.writeBytes(bts)
+	if err != nil {
+		return err
+	}
+
+	if rem := len(bts) % WORD_SIZE; rem != 0 {
+		b.grow(WORD_SIZE - rem)
+	}
 	return err
 }
@@ -0,0 +1,528 @@
+package pfkey
+
+import (
+	"encoding/binary"
+	"io"
+	"unsafe"
+)
+
+// Hand-written encoders and decoders for all the PF_KEY structures.
+// They work on fixed offsets over a []byte, avoiding the reflection (and allocations) of encoding/binary.
+// PF_KEY structures are in host byte order, so order should be nativeEndian except when testing.
+// SPIs and ports are in network order on the wire regardless of order. Their fields hold the
+// value the host would see in memory, so they're written and read through networkOrder16/32.
+// Every unmarshal method returns io.ErrUnexpectedEOF if b is too short to hold the structure, while
+// marshal methods expect b to be at least wireSize() bytes long.
+
+// wireStruct is implemented by every structure that can be written into a PF_KEY message.
+type wireStruct interface {
+	wireSize() int
+	marshal(b []byte, order binary.ByteOrder)
+}
+
+// nativeEndian is the byte order of the host, which is what the kernel expects in PF_KEY messages.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Size in bytes of the structures we need to handle which are not a multiple of WORD_SIZE.
+const sadbExtSize = 4
+
+func (s *SADBMsg) wireSize() int {
+	return SADBMSG_LEN * WORD_SIZE
+}
+
+func (s *SADBMsg) marshal(b []byte, order binary.ByteOrder) {
+	b[0] = s.Version
+	b[1] = s.Type
+	b[2] = s.Errno
+	b[3] = s.SAType
+	order.PutUint16(b[4:], s.Len)
+	order.PutUint16(b[6:], s.Reserved)
+	order.PutUint32(b[8:], s.Seq)
+	order.PutUint32(b[12:], s.PID)
+}
+
+func (s *SADBMsg) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBMSG_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Version = b[0]
+	s.Type = b[1]
+	s.Errno = b[2]
+	s.SAType = b[3]
+	s.Len = order.Uint16(b[4:])
+	s.Reserved = order.Uint16(b[6:])
+	s.Seq = order.Uint32(b[8:])
+	s.PID = order.Uint32(b[12:])
+	return nil
+}
+
+func (s *SADBExt) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < sadbExtSize {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.Type = order.Uint16(b[2:])
+	return nil
+}
+
+func (s *SADBSA) wireSize() int {
+	return SADBSA_LEN * WORD_SIZE
+}
+
+func (s *SADBSA) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	binary.BigEndian.PutUint32(b[4:], networkOrder32(s.SPI, nativeEndian))
+	b[8] = s.Replay
+	b[9] = s.State
+	b[10] = s.Auth
+	b[11] = s.Encrypt
+	order.PutUint32(b[12:], s.Flags)
+}
+
+func (s *SADBSA) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBSA_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.SPI = networkOrder32(binary.BigEndian.Uint32(b[4:]), nativeEndian)
+	s.Replay = b[8]
+	s.State = b[9]
+	s.Auth = b[10]
+	s.Encrypt = b[11]
+	s.Flags = order.Uint32(b[12:])
+	return nil
+}
+
+func (s *SADBLifetime) wireSize() int {
+	return SADBLIFETIME_LEN * WORD_SIZE
+}
+
+func (s *SADBLifetime) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	order.PutUint32(b[4:], s.Allocations)
+	order.PutUint64(b[8:], s.Bytes)
+	order.PutUint64(b[16:], s.Addtime)
+	order.PutUint64(b[24:], s.Usetime)
+}
+
+func (s *SADBLifetime) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBLIFETIME_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Allocations = order.Uint32(b[4:])
+	s.Bytes = order.Uint64(b[8:])
+	s.Addtime = order.Uint64(b[16:])
+	s.Usetime = order.Uint64(b[24:])
+	return nil
+}
+
+func (s *SADBAddress) wireSize() int {
+	return SADBADDRESS_LEN * WORD_SIZE
+}
+
+func (s *SADBAddress) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	b[4] = s.Proto
+	b[5] = s.PrefixLen
+	order.PutUint16(b[6:], s.Reserved)
+}
+
+func (s *SADBAddress) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBADDRESS_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Proto = b[4]
+	s.PrefixLen = b[5]
+	s.Reserved = order.Uint16(b[6:])
+	return nil
+}
+
+func (s *SADBKey) wireSize() int {
+	return SADBKEY_LEN * WORD_SIZE
+}
+
+func (s *SADBKey) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	order.PutUint16(b[4:], s.Bits)
+	order.PutUint16(b[6:], s.Reserved)
+}
+
+func (s *SADBKey) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBKEY_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Bits = order.Uint16(b[4:])
+	s.Reserved = order.Uint16(b[6:])
+	return nil
+}
+
+func (s *SADBProp) wireSize() int {
+	return SADBPROP_LEN * WORD_SIZE
+}
+
+func (s *SADBProp) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	b[4] = s.Replay
+	copy(b[5:8], s.Reserved[:])
+}
+
+func (s *SADBProp) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBPROP_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Replay = b[4]
+	copy(s.Reserved[:], b[5:8])
+	return nil
+}
+
+func (s *SADBComb) wireSize() int {
+	return SADBCOMB_LEN * WORD_SIZE
+}
+
+func (s *SADBComb) marshal(b []byte, order binary.ByteOrder) {
+	b[0] = s.Auth
+	b[1] = s.Encrypt
+	order.PutUint16(b[2:], s.Flags)
+	order.PutUint16(b[4:], s.AuthMinBits)
+	order.PutUint16(b[6:], s.AuthMaxBits)
+	order.PutUint16(b[8:], s.EncryptMinBits)
+	order.PutUint16(b[10:], s.EncryptMaxBits)
+	order.PutUint32(b[12:], s.Reserved)
+	order.PutUint32(b[16:], s.SoftAllocations)
+	order.PutUint32(b[20:], s.HardAllocations)
+	order.PutUint64(b[24:], s.SoftBytes)
+	order.PutUint64(b[32:], s.HardBytes)
+	order.PutUint64(b[40:], s.SoftAddTime)
+	order.PutUint64(b[48:], s.HardAddtime)
+	order.PutUint64(b[56:], s.SoftUsetime)
+	order.PutUint64(b[64:], s.HardUseTime)
+}
+
+func (s *SADBComb) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBCOMB_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Auth = b[0]
+	s.Encrypt = b[1]
+	s.Flags = order.Uint16(b[2:])
+	s.AuthMinBits = order.Uint16(b[4:])
+	s.AuthMaxBits = order.Uint16(b[6:])
+	s.EncryptMinBits = order.Uint16(b[8:])
+	s.EncryptMaxBits = order.Uint16(b[10:])
+	s.Reserved = order.Uint32(b[12:])
+	s.SoftAllocations = order.Uint32(b[16:])
+	s.HardAllocations = order.Uint32(b[20:])
+	s.SoftBytes = order.Uint64(b[24:])
+	s.HardBytes = order.Uint64(b[32:])
+	s.SoftAddTime = order.Uint64(b[40:])
+	s.HardAddtime = order.Uint64(b[48:])
+	s.SoftUsetime = order.Uint64(b[56:])
+	s.HardUseTime = order.Uint64(b[64:])
+	return nil
+}
+
+func (s *SADBXPolicy) wireSize() int {
+	return SADBXPOLICY_LEN * WORD_SIZE
+}
+
+func (s *SADBXPolicy) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	order.PutUint16(b[4:], s.Type)
+	b[6] = s.Dir
+	b[7] = s.Reserved
+	order.PutUint32(b[8:], s.ID)
+	order.PutUint32(b[12:], s.Priority)
+}
+
+func (s *SADBXPolicy) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBXPOLICY_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Type = order.Uint16(b[4:])
+	s.Dir = b[6]
+	s.Reserved = b[7]
+	s.ID = order.Uint32(b[8:])
+	s.Priority = order.Uint32(b[12:])
+	return nil
+}
+
+func (s *SADBSupported) wireSize() int {
+	return SADBSUPPORTED_LEN * WORD_SIZE
+}
+
+func (s *SADBSupported) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	order.PutUint32(b[4:], s.Reserved)
+}
+
+func (s *SADBSupported) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBSUPPORTED_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Reserved = order.Uint32(b[4:])
+	return nil
+}
+
+func (s *SADBAlg) wireSize() int {
+	return SADBALG_LEN * WORD_SIZE
+}
+
+func (s *SADBAlg) marshal(b []byte, order binary.ByteOrder) {
+	b[0] = s.ID
+	b[1] = s.IVLen
+	order.PutUint16(b[2:], s.MinBits)
+	order.PutUint16(b[4:], s.MaxBits)
+	order.PutUint16(b[6:], s.Reserved)
+}
+
+func (s *SADBAlg) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBALG_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.ID = b[0]
+	s.IVLen = b[1]
+	s.MinBits = order.Uint16(b[2:])
+	s.MaxBits = order.Uint16(b[4:])
+	s.Reserved = order.Uint16(b[6:])
+	return nil
+}
+
+func (s *SADBSPIRange) wireSize() int {
+	return SADBSPIRANGE_LEN * WORD_SIZE
+}
+
+func (s *SADBSPIRange) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	order.PutUint32(b[4:], s.Min)
+	order.PutUint32(b[8:], s.Max)
+	order.PutUint32(b[12:], s.Reserved)
+}
+
+func (s *SADBSPIRange) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBSPIRANGE_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Min = order.Uint32(b[4:])
+	s.Max = order.Uint32(b[8:])
+	s.Reserved = order.Uint32(b[12:])
+	return nil
+}
+
+func (s *SADBXSA2) wireSize() int {
+	return SADBXSA2_LEN * WORD_SIZE
+}
+
+func (s *SADBXSA2) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	b[4] = s.Mode
+	b[5] = s.Reserved1
+	order.PutUint16(b[6:], s.Reserved2)
+	order.PutUint32(b[8:], s.Sequence)
+	order.PutUint32(b[12:], s.ReqID)
+}
+
+func (s *SADBXSA2) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBXSA2_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Mode = b[4]
+	s.Reserved1 = b[5]
+	s.Reserved2 = order.Uint16(b[6:])
+	s.Sequence = order.Uint32(b[8:])
+	s.ReqID = order.Uint32(b[12:])
+	return nil
+}
+
+func (s *SADBXNATTType) wireSize() int {
+	return SADBXNATTTYPE_LEN * WORD_SIZE
+}
+
+func (s *SADBXNATTType) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	b[4] = s.Type
+	copy(b[5:8], s.Reserved[:])
+}
+
+func (s *SADBXNATTType) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBXNATTTYPE_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Type = b[4]
+	copy(s.Reserved[:], b[5:8])
+	return nil
+}
+
+func (s *SADBXNATTPort) wireSize() int {
+	return SADBXNATTPORT_LEN * WORD_SIZE
+}
+
+func (s *SADBXNATTPort) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	binary.BigEndian.PutUint16(b[4:], networkOrder16(s.Port, nativeEndian))
+	order.PutUint16(b[6:], s.Reserved)
+}
+
+func (s *SADBXNATTPort) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBXNATTPORT_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Port = networkOrder16(binary.BigEndian.Uint16(b[4:]), nativeEndian)
+	s.Reserved = order.Uint16(b[6:])
+	return nil
+}
+
+func (s *SADBXSecCtx) wireSize() int {
+	return SADBXSECCTX_LEN * WORD_SIZE
+}
+
+func (s *SADBXSecCtx) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	b[4] = s.CtxDOI
+	b[5] = s.CtxAlg
+	order.PutUint16(b[6:], s.CtxLen)
+}
+
+func (s *SADBXSecCtx) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBXSECCTX_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.CtxDOI = b[4]
+	s.CtxAlg = b[5]
+	s.CtxLen = order.Uint16(b[6:])
+	return nil
+}
+
+func (s *SADBXIPSecRequest) wireSize() int {
+	return SADBXIPSECREQUEST_LEN
+}
+
+func (s *SADBXIPSecRequest) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.Proto)
+	b[4] = s.Mode
+	b[5] = s.Level
+	order.PutUint16(b[6:], s.Reserved1)
+	order.PutUint32(b[8:], s.ReqID)
+	order.PutUint32(b[12:], s.Reserved2)
+}
+
+func (s *SADBXIPSecRequest) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBXIPSECREQUEST_LEN {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.Proto = order.Uint16(b[2:])
+	s.Mode = b[4]
+	s.Level = b[5]
+	s.Reserved1 = order.Uint16(b[6:])
+	s.ReqID = order.Uint32(b[8:])
+	s.Reserved2 = order.Uint32(b[12:])
+	return nil
+}
+
+func (s *SADBXKMAddress) wireSize() int {
+	return SADBXKMADDRESS_LEN * WORD_SIZE
+}
+
+func (s *SADBXKMAddress) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], s.Len)
+	order.PutUint16(b[2:], s.ExtType)
+	order.PutUint32(b[4:], s.Reserved)
+}
+
+func (s *SADBXKMAddress) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SADBXKMADDRESS_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Len = order.Uint16(b[0:])
+	s.ExtType = order.Uint16(b[2:])
+	s.Reserved = order.Uint32(b[4:])
+	return nil
+}
+
+func (s sockAddrIn) wireSize() int {
+	return SOCKADDRIN_LEN * WORD_SIZE
+}
+
+func (s sockAddrIn) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], uint16(s.SinFamily))
+	binary.BigEndian.PutUint16(b[2:], networkOrder16(s.SinPort, nativeEndian))
+	copy(b[4:8], s.SinAddr[:])
+	copy(b[8:16], s.SinZero[:])
+}
+
+func (s *sockAddrIn) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SOCKADDRIN_LEN*WORD_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.SinFamily = int16(order.Uint16(b[0:]))
+	s.SinPort = networkOrder16(binary.BigEndian.Uint16(b[2:]), nativeEndian)
+	copy(s.SinAddr[:], b[4:8])
+	copy(s.SinZero[:], b[8:16])
+	return nil
+}
+
+func (s sockAddrIn6) wireSize() int {
+	return SOCKADDRIN6_SIZE
+}
+
+func (s sockAddrIn6) marshal(b []byte, order binary.ByteOrder) {
+	order.PutUint16(b[0:], uint16(s.Sin6Family))
+	binary.BigEndian.PutUint16(b[2:], networkOrder16(s.Sin6Port, nativeEndian))
+	binary.BigEndian.PutUint32(b[4:], networkOrder32(s.Sin6Flowinfo, nativeEndian))
+	copy(b[8:24], s.Sin6Addr[:])
+	order.PutUint32(b[24:], s.Sin6ScopeID)
+}
+
+func (s *sockAddrIn6) unmarshal(b []byte, order binary.ByteOrder) error {
+	if len(b) < SOCKADDRIN6_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	s.Sin6Family = int16(order.Uint16(b[0:]))
+	s.Sin6Port = networkOrder16(binary.BigEndian.Uint16(b[2:]), nativeEndian)
+	s.Sin6Flowinfo = networkOrder32(binary.BigEndian.Uint32(b[4:]), nativeEndian)
+	copy(s.Sin6Addr[:], b[8:24])
+	s.Sin6ScopeID = order.Uint32(b[24:])
+	return nil
+}
@@ -0,0 +1,422 @@
+package pfkey
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// getBytes returns an arbitrary object as a slice of bytes in host order using encoding/binary.
+func getBytes(object interface{}) ([]byte, error) {
+	return getBytesOrder(nativeEndian, object)
+}
+
+// getBytesOrder returns an arbitrary object as a slice of bytes using encoding/binary.
+// It's the reference implementation the hand-written codec is checked against.
+func getBytesOrder(order binary.ByteOrder, object interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, order, object)
+	return buf.Bytes(), err
+}
+
+// byteOrders are the byte orders every codec test runs with, regardless of the host's.
+var byteOrders = []binary.ByteOrder{binary.LittleEndian, binary.BigEndian}
+
+type codecStruct interface {
+	wireStruct
+	unmarshal(b []byte, order binary.ByteOrder) error
+}
+
+// codecStructs returns an instance of every structure handled by the codec.
+func codecStructs() []codecStruct {
+	return []codecStruct{
+		&SADBMsg{},
+		&SADBSA{},
+		&SADBLifetime{},
+		&SADBAddress{},
+		&SADBKey{},
+		&SADBProp{},
+		&SADBComb{},
+		&SADBXPolicy{},
+		&SADBSupported{},
+		&SADBAlg{},
+		&SADBSPIRange{},
+		&SADBXSA2{},
+		&SADBXNATTType{},
+		&SADBXNATTPort{},
+		&SADBXSecCtx{},
+		&SADBXIPSecRequest{},
+		&SADBXKMAddress{},
+		&sockAddrIn{},
+		&sockAddrIn6{},
+	}
+}
+
+// fillStruct sets every field of the struct pointed by v to a different non-zero value,
+// so misplaced fields are caught when comparing encodings.
+func fillStruct(v interface{}) {
+	seed := uint64(0x0102030405060708)
+	var fill func(f reflect.Value)
+	fill = func(f reflect.Value) {
+		switch f.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f.SetUint(seed)
+		case reflect.Int16:
+			f.SetInt(int64(seed & 0x7fff))
+		case reflect.Array:
+			for i := 0; i < f.Len(); i++ {
+				fill(f.Index(i))
+			}
+			return
+		}
+		seed = seed*31 + 17
+	}
+
+	s := reflect.ValueOf(v).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		fill(s.Field(i))
+	}
+}
+
+func TestCodecMatchesEncodingBinary(t *testing.T) {
+	for _, order := range byteOrders {
+		for _, s := range codecStructs() {
+			fillStruct(s)
+			name := fmt.Sprintf("%s (%s)", reflect.TypeOf(s).Elem().Name(), order)
+
+			expected, err := getBytesOrder(order, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.wireSize() != len(expected) {
+				t.Errorf("%s: wireSize is %d but encoding/binary writes %d bytes", name, s.wireSize(), len(expected))
+				continue
+			}
+
+			b := make([]byte, s.wireSize())
+			s.marshal(b, order)
+			// encoding/binary only matches our network order fields when using the host's byte order
+			if order == nativeEndian && !bytes.Equal(b, expected) {
+				t.Errorf("%s: expected %+v but got %+v instead", name, expected, b)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(s).Elem()).Interface().(codecStruct)
+			if err = decoded.unmarshal(b, order); err != nil {
+				t.Errorf("%s: %s", name, err)
+			}
+			if !reflect.DeepEqual(decoded, s) {
+				t.Errorf("%s: expected %+v but got %+v instead", name, s, decoded)
+			}
+		}
+	}
+}
+
+func TestCodecNetworkOrderFields(t *testing.T) {
+	for _, order := range byteOrders {
+		sa := SADBSA{SPI: networkOrder32(0x11223344, nativeEndian)}
+		b := make([]byte, sa.wireSize())
+		sa.marshal(b, order)
+		if !bytes.Equal(b[4:8], []byte{0x11, 0x22, 0x33, 0x44}) {
+			t.Errorf("%s: expected SPI in network order but got %x", order, b[4:8])
+		}
+
+		port := SADBXNATTPort{Port: networkOrder16(4500, nativeEndian)}
+		b = make([]byte, port.wireSize())
+		port.marshal(b, order)
+		if !bytes.Equal(b[4:6], []byte{0x11, 0x94}) {
+			t.Errorf("%s: expected NAT-T port in network order but got %x", order, b[4:6])
+		}
+
+		addr := sockAddrIn{SinFamily: 2, SinPort: networkOrder16(500, nativeEndian)}
+		b = make([]byte, addr.wireSize())
+		addr.marshal(b, order)
+		if !bytes.Equal(b[2:4], []byte{0x01, 0xf4}) {
+			t.Errorf("%s: expected sockaddr port in network order but got %x", order, b[2:4])
+		}
+
+		var decoded SADBSA
+		if err := decoded.unmarshal([]byte{2, 0, 1, 0, 0x11, 0x22, 0x33, 0x44, 0, 0, 0, 0, 0, 0, 0, 0}, order); err != nil {
+			t.Fatal(err)
+		}
+		if spi := decoded.GetSPI(); spi != 0x11223344 {
+			t.Errorf("%s: expected SPI 0x11223344 but got %#x", order, spi)
+		}
+	}
+}
+
+func TestMsgByteOrder(t *testing.T) {
+	src := Node{Addr: net.IPv4(1, 2, 3, 4)}
+	dst := Node{Addr: net.IPv4(5, 6, 7, 8)}
+	msg, err := BuildSADBADD(1337, 31337, src, dst, expectedAddMsg.Extensions.EncryptKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.SetNATTSport(4500)
+	msg.setMsgLen()
+
+	for _, order := range byteOrders {
+		buf := &msgBuffer{order: order}
+		if err = msg.writeToBuffer(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if l := order.Uint16(buf.buf[4:]); int(l)*WORD_SIZE != len(buf.buf) {
+			t.Errorf("%s: message length %d doesn't match the %d bytes written", order, l, len(buf.buf))
+		}
+
+		received, err := parseMsg(buf.buf, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if received.Msg != msg.Msg || received.Extensions.SA != msg.Extensions.SA {
+			t.Errorf("%s: expected %+v but got %+v instead", order, msg, received)
+		}
+		if port := received.Extensions.NATTSport.GetPort(); port != 4500 {
+			t.Errorf("%s: expected NAT-T source port 4500 but got %d", order, port)
+		}
+	}
+}
+
+func TestCodecShortBuffer(t *testing.T) {
+	for _, s := range codecStructs() {
+		b := make([]byte, s.wireSize()-1)
+		if err := s.unmarshal(b, nativeEndian); err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: expected %v but got %v instead", reflect.TypeOf(s).Elem().Name(), io.ErrUnexpectedEOF, err)
+		}
+	}
+}
+
+func TestParseHeaderAllocations(t *testing.T) {
+	b := sadbDumpResponse
+	allocs := testing.AllocsPerRun(100, func() {
+		var hdr SADBMsg
+		hdr.unmarshal(b, nativeEndian)
+		var ext SADBExt
+		ext.unmarshal(b[SADBMSG_LEN*WORD_SIZE:], nativeEndian)
+	})
+
+	if allocs != 0 {
+		t.Errorf("Expected no allocations when parsing the header but got %v", allocs)
+	}
+}
+
+func TestParseMsgMatchesReflect(t *testing.T) {
+	for name, b := range benchmarkFixtures(t) {
+		expected, err := parseMsgReflect(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received, err := ParseMsg(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = compareMessages(expected, received); err != nil {
+			t.Errorf("On fixture %s: %s", name, err)
+		}
+	}
+}
+
+func TestParseMsgInvalidExtensionLength(t *testing.T) {
+	// A sadb_ext with a length of 0 would otherwise make us loop forever
+	b := []byte{2, 10, 0, 3, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0}
+	if _, err := ParseMsg(b); err == nil {
+		t.Error("Expected an error when parsing an extension with a length of 0")
+	}
+
+	// The extension claims to be longer than the message
+	b = []byte{2, 10, 0, 3, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 0, 0, 0, 0, 0}
+	if _, err := ParseMsg(b); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %v but got %v instead", io.ErrUnexpectedEOF, err)
+	}
+}
+
+// parseMsgReflect parses the extensions present in our fixtures using encoding/binary,
+// the way ReadMsg used to do it. It's only used as a baseline for tests and benchmarks.
+func parseMsgReflect(b []byte) (Msg, error) {
+	var msg Msg
+	buf := bytes.NewReader(b)
+
+	err := binary.Read(buf, binary.LittleEndian, &msg.Msg)
+	if err != nil {
+		return msg, err
+	}
+
+	for buf.Len() > 0 {
+		var ext SADBExt
+		extBytes := b[len(b)-buf.Len():]
+		err = binary.Read(bytes.NewReader(extBytes), binary.LittleEndian, &ext)
+		if err != nil {
+			return msg, err
+		}
+
+		switch ext.Type {
+		case SADB_EXT_SA:
+			var sa SADBSA
+			err = binary.Read(buf, binary.LittleEndian, &sa)
+			msg.SetSA(sa)
+		case SADB_EXT_LIFETIME_CURRENT, SADB_EXT_LIFETIME_HARD, SADB_EXT_LIFETIME_SOFT:
+			var lt SADBLifetime
+			err = binary.Read(buf, binary.LittleEndian, &lt)
+			switch ext.Type {
+			case SADB_EXT_LIFETIME_CURRENT:
+				msg.SetLifetimeCurrent(lt)
+			case SADB_EXT_LIFETIME_HARD:
+				msg.SetLifetimeHard(lt)
+			default:
+				msg.SetLifetimeSoft(lt)
+			}
+		case SADB_EXT_ADDRESS_SRC, SADB_EXT_ADDRESS_DST, SADB_EXT_ADDRESS_PROXY:
+			var address SADBAddress
+			var sckaddr sockAddrIn
+			binary.Read(buf, binary.LittleEndian, &address)
+			err = binary.Read(buf, binary.LittleEndian, &sckaddr)
+			// Address fields are kept as received, like ParseMsg does
+			switch ext.Type {
+			case SADB_EXT_ADDRESS_SRC:
+				msg.Extensions.AddressSrc, msg.Extensions.SockAddrSrc = address, sckaddr
+				msg.Present.AddressSrc = true
+			case SADB_EXT_ADDRESS_DST:
+				msg.Extensions.AddressDst, msg.Extensions.SockAddrDst = address, sckaddr
+				msg.Present.AddressDst = true
+			default:
+				msg.Extensions.AddressProxy, msg.Extensions.SockAddrProxy = address, sckaddr
+				msg.Present.AddressProxy = true
+			}
+		case SADB_EXT_SUPPORTED_AUTH, SADB_EXT_SUPPORTED_ENCRYPT:
+			var supported SADBSupported
+			binary.Read(buf, binary.LittleEndian, &supported)
+			algs := make([]SADBAlg, supported.Len-1)
+			err = binary.Read(buf, binary.LittleEndian, algs)
+			if ext.Type == SADB_EXT_SUPPORTED_AUTH {
+				msg.SetAuthAlgorithms(algs)
+			} else {
+				msg.SetEncryptAlgorithms(algs)
+			}
+		case SADB_X_EXT_SA2:
+			var sa2 SADBXSA2
+			err = binary.Read(buf, binary.LittleEndian, &sa2)
+			msg.SetSA2(sa2)
+		default:
+			_, err = buf.Seek(int64(ext.Len)*WORD_SIZE, io.SeekCurrent)
+		}
+
+		if err != nil {
+			return msg, err
+		}
+	}
+
+	return msg, nil
+}
+
+// writeMsgReflect encodes the extensions of a SADB_ADD message using encoding/binary,
+// the way writeToBuffer used to do it. It's only used as a baseline for benchmarks.
+func writeMsgReflect(msg *Msg) []byte {
+	buf := new(bytes.Buffer)
+	for _, s := range []interface{}{
+		msg.Msg,
+		msg.Extensions.SA,
+		msg.Extensions.LifetimeHard,
+		msg.Extensions.LifetimeSoft,
+		msg.Extensions.AddressSrc,
+		msg.Extensions.SockAddrSrc,
+		msg.Extensions.AddressDst,
+		msg.Extensions.SockAddrDst,
+		msg.Extensions.EncryptKey,
+	} {
+		binary.Write(buf, binary.LittleEndian, s)
+	}
+	buf.Write(msg.Extensions.EncryptKeyBits)
+
+	return buf.Bytes()
+}
+
+// benchmarkFixtures returns the messages in test-fixtures/messages.json along with a SADB_DUMP reply.
+func benchmarkFixtures(tb testing.TB) map[string][]byte {
+	fixtures := loadFixtures(tb)
+	fixtures["sadb_dump"] = sadbDumpResponse
+	return fixtures
+}
+
+func benchmarkParse(b *testing.B, name string, parse func([]byte) (Msg, error)) {
+	msg := benchmarkFixtures(b)[name]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parse(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseRegistration(b *testing.B) {
+	benchmarkParse(b, "registration_1", ParseMsg)
+}
+
+func BenchmarkParseRegistrationReflect(b *testing.B) {
+	benchmarkParse(b, "registration_1", parseMsgReflect)
+}
+
+func BenchmarkParseDump(b *testing.B) {
+	benchmarkParse(b, "sadb_dump", ParseMsg)
+}
+
+func BenchmarkParseDumpReflect(b *testing.B) {
+	benchmarkParse(b, "sadb_dump", parseMsgReflect)
+}
+
+func BenchmarkParseHeader(b *testing.B) {
+	msg := sadbDumpResponse
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var hdr SADBMsg
+		hdr.unmarshal(msg, nativeEndian)
+	}
+}
+
+func BenchmarkParseHeaderReflect(b *testing.B) {
+	msg := sadbDumpResponse
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var hdr SADBMsg
+		binary.Read(bytes.NewReader(msg), binary.LittleEndian, &hdr)
+	}
+}
+
+func benchmarkAddMsg(b *testing.B) *Msg {
+	src := Node{Addr: net.IPv4(1, 2, 3, 4)}
+	dst := Node{Addr: net.IPv4(5, 6, 7, 8)}
+	msg, err := BuildSADBADD(1337, 31337, src, dst, expectedAddMsg.Extensions.EncryptKeyBits)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return msg
+}
+
+func BenchmarkEncodeAdd(b *testing.B) {
+	msg := benchmarkAddMsg(b)
+	buf := new(msgBuffer)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.buf = buf.buf[:0]
+		if err := msg.writeToBuffer(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeAddReflect(b *testing.B) {
+	msg := benchmarkAddMsg(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		writeMsgReflect(msg)
+	}
+}
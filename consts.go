@@ -13,26 +13,39 @@ const (
 
 // SADB Struct sizes
 const (
-	SADBMSG_LEN       = 2
-	SADBSA_LEN        = 2
-	SADBLIFETIME_LEN  = 4
-	SADBADDRESS_LEN   = 1
-	SADBKEY_LEN       = 1
-	SADBIDENT_LEN     = 2
-	SADBSENS_LEN      = 2
-	SADBPROP_LEN      = 1
-	SADBCOMB_LEN      = 9
-	SADBSUPPORTED_LEN = 1
-	SADBALG_LEN       = 1
-	SADBSPIRANGE_LEN  = 2
-	SADBXPOLICY_LEN   = 2
+	SADBMSG_LEN        = 2
+	SADBSA_LEN         = 2
+	SADBLIFETIME_LEN   = 4
+	SADBADDRESS_LEN    = 1
+	SADBKEY_LEN        = 1
+	SADBIDENT_LEN      = 2
+	SADBSENS_LEN       = 2
+	SADBPROP_LEN       = 1
+	SADBCOMB_LEN       = 9
+	SADBSUPPORTED_LEN  = 1
+	SADBALG_LEN        = 1
+	SADBSPIRANGE_LEN   = 2
+	SADBXPOLICY_LEN    = 2
+	SADBXSA2_LEN       = 2
+	SADBXNATTTYPE_LEN  = 1
+	SADBXNATTPORT_LEN  = 1
+	SADBXSECCTX_LEN    = 1
+	SADBXKMADDRESS_LEN = 1
 )
 
+// SADBXIPSECREQUEST_LEN is the size of a sadb_x_ipsecrequest.
+// Unlike the rest of PF_KEY structs, sadb_x_ipsecrequest expresses its length in bytes instead of words.
+const SADBXIPSECREQUEST_LEN = 16
+
 // Other struct sizes
 const (
 	SOCKADDRIN_LEN = 2
 )
 
+// Size in bytes of a sockaddr_in6, which (unlike sockaddr_in) isn't a multiple of 64 bits.
+// PF_KEY pads it to the next 64-bit boundary inside sadb_address extensions.
+const SOCKADDRIN6_SIZE = 28
+
 // SADB SA types
 const (
 	SADB_SATYPE_UNSPEC   = 0
@@ -98,6 +111,12 @@ const (
 	SADB_X_EXT_KMPRIVATE
 	SADB_X_EXT_POLICY
 	SADB_X_EXT_SA2
+	SADB_X_EXT_NAT_T_TYPE
+	SADB_X_EXT_NAT_T_SPORT
+	SADB_X_EXT_NAT_T_DPORT
+	SADB_X_EXT_NAT_T_OA
+	SADB_X_EXT_SEC_CTX
+	SADB_X_EXT_KMADDRESS
 )
 
 // SA STATES
@@ -108,6 +127,70 @@ const (
 	SADB_SASTATE_DEAD
 )
 
+// SA flags, as used by the sadb_sa extension. All but SADB_SAFLAGS_PFS are Linux specific.
+const (
+	SADB_SAFLAGS_PFS        = 1
+	SADB_SAFLAGS_NOPMTUDISC = 0x20000000
+	SADB_SAFLAGS_DECAP_DSCP = 0x40000000
+	SADB_SAFLAGS_NOECN      = 0x80000000
+)
+
+// saFlagsMask holds all the SA flags we know about.
+const saFlagsMask = SADB_SAFLAGS_PFS | SADB_SAFLAGS_NOPMTUDISC | SADB_SAFLAGS_DECAP_DSCP | SADB_SAFLAGS_NOECN
+
+// maxReplayWindow is the largest replay window (in packets) that fits in the 8-bit sadb_sa_replay field.
+// Linux caps it further to the 32 packets of its replay bitmap.
+const maxReplayWindow = 255
+
+// IPsec modes, as used by the sadb_x_sa2 extension
+const (
+	IPSEC_MODE_ANY = iota
+	IPSEC_MODE_TRANSPORT
+	IPSEC_MODE_TUNNEL
+)
+
+// UDP encapsulation types, as used by the sadb_x_nat_t_type extension
+const (
+	UDP_ENCAP_ESPINUDP_NON_IKE = 1
+	UDP_ENCAP_ESPINUDP         = 2
+)
+
+// IPsec policy types, as used by the sadb_x_policy extension
+const (
+	IPSEC_POLICY_DISCARD = iota
+	IPSEC_POLICY_NONE
+	IPSEC_POLICY_IPSEC
+	IPSEC_POLICY_ENTRUST
+	IPSEC_POLICY_BYPASS
+)
+
+// IPsec policy directions, as used by the sadb_x_policy extension
+const (
+	IPSEC_DIR_ANY = iota
+	IPSEC_DIR_INBOUND
+	IPSEC_DIR_OUTBOUND
+	IPSEC_DIR_FWD
+)
+
+// IPsec levels, as used by the sadb_x_ipsecrequest structure
+const (
+	IPSEC_LEVEL_DEFAULT = iota
+	IPSEC_LEVEL_USE
+	IPSEC_LEVEL_REQUIRE
+	IPSEC_LEVEL_UNIQUE
+)
+
+// IPSEC_ULPROTO_ANY is the upper-layer protocol used in selectors to match any protocol.
+const IPSEC_ULPROTO_ANY = 255
+
+// Security context DOIs and algorithms, as used by the sadb_x_sec_ctx extension
+const (
+	XFRM_SC_DOI_RESERVED = 0
+	XFRM_SC_DOI_LSM      = 1
+	XFRM_SC_ALG_RESERVED = 0
+	XFRM_SC_ALG_SELINUX  = 1
+)
+
 // Encryption algorithms
 const (
 	SADB_EALG_NONE            = 0
@@ -157,3 +240,13 @@ const (
 	spiRangeMin = 10
 	spiRangeMax = 10000000
 )
+
+// IPComp SAs are identified by a 16-bit CPI instead of an SPI, and CPIs below 256 are reserved (rfc3173).
+const (
+	cpiRangeMin = 0x100
+	cpiRangeMax = 0xffff
+)
+
+// maxSecCtxLen is the largest security context the kernel accepts: it refuses sadb_x_ctx_len values larger than
+// a page, which is 4096 bytes on x86.
+const maxSecCtxLen = 4096
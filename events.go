@@ -0,0 +1,119 @@
+package pfkey
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// NATMappingChanged holds the information carried by a SADB_X_NAT_T_NEW_MAPPING message,
+// which the kernel sends when the address or port of a NAT-T peer changes.
+type NATMappingChanged struct {
+	SAType uint8
+	// SPI of the affected SA, in machine order.
+	SPI uint32
+	// Old and New are the address and NAT-T port of the peer before and after the change. Ports are in machine order.
+	Old netip.AddrPort
+	New netip.AddrPort
+}
+
+// DecodeNATMapping decodes a SADB_X_NAT_T_NEW_MAPPING message into a NATMappingChanged.
+func (p *Msg) DecodeNATMapping() (NATMappingChanged, error) {
+	var m NATMappingChanged
+
+	if p.Msg.Type != SADB_X_NAT_T_NEW_MAPPING {
+		return m, fmt.Errorf("unexpected message type %d, expected SADB_X_NAT_T_NEW_MAPPING", p.Msg.Type)
+	}
+
+	if !p.HasSA() || !p.HasAddressSrc() || !p.HasAddressDst() || !p.HasNATTSport() || !p.HasNATTDport() {
+		return m, errors.New("SADB_X_NAT_T_NEW_MAPPING message is missing required extensions")
+	}
+
+	m.SAType = p.Msg.SAType
+	m.SPI = p.Extensions.SA.GetSPI()
+	m.Old = netip.AddrPortFrom(p.Extensions.SockAddrSrc.addrPort().Addr(), p.Extensions.NATTSport.GetPort())
+	m.New = netip.AddrPortFrom(p.Extensions.SockAddrDst.addrPort().Addr(), p.Extensions.NATTDport.GetPort())
+
+	return m, nil
+}
+
+// ReadNATMappingChanged listens for a SADB_X_NAT_T_NEW_MAPPING message from the kernel and returns it decoded.
+// It will ignore and skip messages of any other type received through the socket.
+func (p *PFKEY) ReadNATMappingChanged() (NATMappingChanged, error) {
+	for {
+		msg, err := p.ReadMsg()
+		if err != nil {
+			return NATMappingChanged{}, err
+		}
+
+		if msg.Msg.Type != SADB_X_NAT_T_NEW_MAPPING {
+			continue
+		}
+
+		return msg.DecodeNATMapping()
+	}
+}
+
+// SAExpired holds the information carried by a SADB_EXPIRE message, which the kernel sends to registered
+// sockets when an SA (of any type, including AH) reaches its soft or hard lifetime.
+type SAExpired struct {
+	SAType uint8
+	// SPI of the expired SA, in machine order.
+	SPI uint32
+	// Src and Dst ports are in machine order.
+	Src netip.AddrPort
+	Dst netip.AddrPort
+	// Hard is true if the SA reached its hard lifetime (and has been removed) and false for its soft lifetime.
+	Hard bool
+	// Usage holds how much the SA was used and Limit the lifetime it reached.
+	Usage Usage
+	Limit Lifetime
+}
+
+// DecodeExpire decodes a SADB_EXPIRE message into a SAExpired.
+func (p *Msg) DecodeExpire() (SAExpired, error) {
+	var e SAExpired
+
+	if p.Msg.Type != SADB_EXPIRE {
+		return e, fmt.Errorf("unexpected message type %d, expected SADB_EXPIRE", p.Msg.Type)
+	}
+
+	if !p.HasSA() || !p.HasAddressSrc() || !p.HasAddressDst() || !p.HasLifetimeCurrent() {
+		return e, errors.New("SADB_EXPIRE message is missing required extensions")
+	}
+
+	switch {
+	case p.HasLifetimeHard():
+		e.Hard = true
+		e.Limit = newLifetime(p.Extensions.LifetimeHard)
+	case p.HasLifetimeSoft():
+		e.Limit = newLifetime(p.Extensions.LifetimeSoft)
+	default:
+		return e, errors.New("SADB_EXPIRE message is missing its soft or hard lifetime")
+	}
+
+	e.SAType = p.Msg.SAType
+	e.SPI = p.Extensions.SA.GetSPI()
+	e.Src = p.Extensions.SockAddrSrc.addrPort()
+	e.Dst = p.Extensions.SockAddrDst.addrPort()
+	e.Usage = newUsage(p.Extensions.LifetimeCurrent)
+
+	return e, nil
+}
+
+// ReadSAExpired listens for a SADB_EXPIRE message from the kernel and returns it decoded.
+// It will ignore and skip messages of any other type received through the socket.
+func (p *PFKEY) ReadSAExpired() (SAExpired, error) {
+	for {
+		msg, err := p.ReadMsg()
+		if err != nil {
+			return SAExpired{}, err
+		}
+
+		if msg.Msg.Type != SADB_EXPIRE {
+			continue
+		}
+
+		return msg.DecodeExpire()
+	}
+}
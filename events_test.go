@@ -0,0 +1,135 @@
+package pfkey
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func buildNATMappingMsg() Msg {
+	msg := Msg{
+		Msg: SADBMsg{
+			Version: PF_KEY_V2,
+			Type:    SADB_X_NAT_T_NEW_MAPPING,
+			SAType:  SADB_SATYPE_ESP,
+		},
+	}
+	msg.SetSA(SADBSA{SPI: networkOrder32(31337, nativeEndian)})
+	msg.SetAddressSrc(Node{Addr: net.IPv4(10, 0, 0, 1)})
+	msg.SetNATTSport(4500)
+	msg.SetAddressDst(Node{Addr: net.IPv4(10, 0, 0, 2)})
+	msg.SetNATTDport(61000)
+
+	return msg
+}
+
+func TestDecodeNATMapping(t *testing.T) {
+	msg := buildNATMappingMsg()
+
+	// Make sure we go through an encode/decode cycle, as we would when reading from the kernel.
+	received := roundTripMsg(t, msg)
+
+	m, err := received.DecodeNATMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.SAType != SADB_SATYPE_ESP || m.SPI != 31337 {
+		t.Errorf("Unexpected SA in decoded mapping: %+v", m)
+	}
+
+	if m.Old != netip.MustParseAddrPort("10.0.0.1:4500") {
+		t.Errorf("Unexpected old address in decoded mapping: %+v", m)
+	}
+
+	if m.New != netip.MustParseAddrPort("10.0.0.2:61000") {
+		t.Errorf("Unexpected new address in decoded mapping: %+v", m)
+	}
+}
+
+func TestDecodeNATMappingWrongType(t *testing.T) {
+	msg := buildNATMappingMsg()
+	msg.Msg.Type = SADB_ADD
+
+	if _, err := msg.DecodeNATMapping(); err == nil {
+		t.Error("Expected an error when decoding a message of the wrong type")
+	}
+}
+
+func TestDecodeNATMappingMissingExtensions(t *testing.T) {
+	msg := buildNATMappingMsg()
+	msg.Present.NATTDport = false
+
+	if _, err := msg.DecodeNATMapping(); err == nil {
+		t.Error("Expected an error when decoding a message with missing extensions")
+	}
+}
+
+func TestReadNATMappingChanged(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		defer server.Close()
+
+		p := PFKEY{socket: server}
+		p.SendMsg(BuildSADBFLUSH())
+		p.SendMsg(buildNATMappingMsg())
+	}()
+
+	p := PFKEY{socket: client}
+
+	m, err := p.ReadNATMappingChanged()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.SPI != 31337 || m.New.Port() != 61000 {
+		t.Errorf("Unexpected decoded mapping: %+v", m)
+	}
+}
+
+func TestDecodeExpire(t *testing.T) {
+	msg := Msg{
+		Msg: SADBMsg{
+			Version: PF_KEY_V2,
+			Type:    SADB_EXPIRE,
+			SAType:  SADB_SATYPE_AH,
+		},
+	}
+	msg.SetSA(SADBSA{SPI: networkOrder32(31337, nativeEndian), Auth: SADB_X_AALG_SHA2_256HMAC})
+	msg.SetLifetimeCurrent(SADBLifetime{Bytes: 4096, Addtime: 1700000000})
+	msg.SetLifetimeSoft(SADBLifetime{Addtime: 60})
+	msg.SetAddressSrcAddrPort(netip.MustParseAddrPort("10.0.0.1:4500"))
+	msg.SetAddressDstAddrPort(netip.MustParseAddrPort("10.0.0.2:4500"))
+
+	received := roundTripMsg(t, msg)
+
+	e, err := received.DecodeExpire()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.SAType != SADB_SATYPE_AH || e.SPI != 31337 || e.Hard {
+		t.Errorf("Unexpected SA in decoded expire: %+v", e)
+	}
+	if e.Usage.Bytes != 4096 || e.Usage.AddedAt.Unix() != 1700000000 || !e.Usage.FirstUsedAt.IsZero() || e.Limit.AddTime != time.Minute {
+		t.Errorf("Unexpected lifetimes in decoded expire: %+v", e)
+	}
+	if e.Src != netip.MustParseAddrPort("10.0.0.1:4500") || e.Dst != netip.MustParseAddrPort("10.0.0.2:4500") {
+		t.Errorf("Unexpected addresses in decoded expire: %+v", e)
+	}
+
+	// The decoded SA of an expire message needs to agree with the one DecodeSA returns
+	info, err := received.DecodeSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SPI != e.SPI || info.Src != e.Src || info.Dst != e.Dst {
+		t.Errorf("Decoded expire %+v doesn't match decoded SA %+v", e, info)
+	}
+
+	received.Present.LifetimeSoft = false
+	if _, err = received.DecodeExpire(); err == nil {
+		t.Error("Expected an error when decoding an expire message without soft or hard lifetimes")
+	}
+}
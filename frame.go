@@ -0,0 +1,182 @@
+package pfkey
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrExtensionNotPresent is returned by the Frame accessors when the requested extension is not in the message.
+var ErrExtensionNotPresent = errors.New("extension not present in message")
+
+// Frame is a read-only view over a raw PF_KEY message, as received from the socket.
+// Extensions are only decoded when asked for and never copied, so a Frame is
+// only valid for as long as the slice of bytes it was created from isn't modified.
+type Frame struct {
+	b     []byte
+	order binary.ByteOrder
+	hdr   SADBMsg
+}
+
+// NewFrame creates a Frame over the PF_KEY message in b, decoding only its base header.
+func NewFrame(b []byte) (Frame, error) {
+	return newFrame(b, nativeEndian)
+}
+
+// newFrame creates a Frame over a PF_KEY message whose structures were encoded using the given byte order.
+func newFrame(b []byte, order binary.ByteOrder) (Frame, error) {
+	f := Frame{b: b, order: order}
+
+	if len(b) == 0 {
+		return f, io.EOF
+	}
+
+	err := f.hdr.unmarshal(b, order)
+	return f, err
+}
+
+// Header returns the base header (sadb_msg) of this Frame.
+func (f Frame) Header() SADBMsg {
+	return f.hdr
+}
+
+// Extensions returns an iterator over the extensions in this Frame.
+func (f Frame) Extensions() ExtensionIterator {
+	if len(f.b) < SADBMSG_LEN*WORD_SIZE {
+		return ExtensionIterator{err: io.ErrUnexpectedEOF}
+	}
+
+	return ExtensionIterator{
+		b:     f.b[SADBMSG_LEN*WORD_SIZE:],
+		order: f.order,
+	}
+}
+
+// Extension returns the raw bytes of the first extension of type extType in this Frame.
+func (f Frame) Extension(extType uint16) ([]byte, error) {
+	it := f.Extensions()
+	for it.Next() {
+		if it.Type() == extType {
+			return it.Bytes(), nil
+		}
+	}
+
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+	return nil, ErrExtensionNotPresent
+}
+
+// SA returns the SA extension of this Frame.
+func (f Frame) SA() (SADBSA, error) {
+	var sa SADBSA
+
+	ext, err := f.Extension(SADB_EXT_SA)
+	if err != nil {
+		return sa, err
+	}
+
+	err = sa.unmarshal(ext, f.order)
+	return sa, err
+}
+
+// Src returns the source address of this Frame.
+func (f Frame) Src() (Node, error) {
+	ext, err := f.Extension(SADB_EXT_ADDRESS_SRC)
+	if err != nil {
+		return Node{}, err
+	}
+	return parseNode(ext, f.order)
+}
+
+// Dst returns the destination address of this Frame.
+func (f Frame) Dst() (Node, error) {
+	ext, err := f.Extension(SADB_EXT_ADDRESS_DST)
+	if err != nil {
+		return Node{}, err
+	}
+	return parseNode(ext, f.order)
+}
+
+// Msg fully decodes this Frame into a Msg.
+func (f Frame) Msg() (Msg, error) {
+	newMsg := Msg{Msg: f.hdr}
+
+	// TODO: We need to do some validation on the message itself. For example:
+	// TODO: Validate that the version is valid (only one possible value: PF_KEY_V2)
+	// TODO: Validate that the len field makes sense, and use it when parsing the message
+	// TODO: Validate that we get as much data as the len field says we're getting
+
+	it := f.Extensions()
+	for it.Next() {
+		err := newMsg.parseExtension(it.Type(), it.Bytes(), f.order)
+		if err != nil {
+			return newMsg, err
+		}
+	}
+
+	return newMsg, it.Err()
+}
+
+// ExtensionIterator walks through the extensions of a Frame. Use it like this:
+//
+//	it := frame.Extensions()
+//	for it.Next() {
+//		// it.Type() and it.Bytes() hold the current extension
+//	}
+//	if it.Err() != nil {
+//		// the message was malformed
+//	}
+type ExtensionIterator struct {
+	b       []byte
+	order   binary.ByteOrder
+	extType uint16
+	ext     []byte
+	err     error
+}
+
+// Next advances the iterator to the next extension. It returns false when there are
+// no extensions left or when a malformed one is found, in which case Err will return the reason.
+func (it *ExtensionIterator) Next() bool {
+	if it.err != nil || len(it.b) == 0 {
+		return false
+	}
+
+	var newExt SADBExt
+	it.err = newExt.unmarshal(it.b, it.order)
+	if it.err != nil {
+		return false
+	}
+
+	extLen := int(newExt.Len) * WORD_SIZE
+	if extLen == 0 {
+		it.err = fmt.Errorf("received extension %d with an invalid length of 0", newExt.Type)
+		return false
+	}
+	if extLen > len(it.b) {
+		it.err = io.ErrUnexpectedEOF
+		return false
+	}
+
+	it.extType = newExt.Type
+	it.ext = it.b[:extLen:extLen]
+	it.b = it.b[extLen:]
+	return true
+}
+
+// Type returns the type (one of SADB_EXT_* or SADB_X_EXT_*) of the current extension.
+func (it *ExtensionIterator) Type() uint16 {
+	return it.extType
+}
+
+// Bytes returns the current extension, including its sadb_ext header.
+// The slice points into the Frame's buffer, no data is copied.
+func (it *ExtensionIterator) Bytes() []byte {
+	return it.ext
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ExtensionIterator) Err() error {
+	return it.err
+}
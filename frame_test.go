@@ -0,0 +1,144 @@
+package pfkey
+
+import (
+	"io"
+	"testing"
+)
+
+func TestFrameExtensions(t *testing.T) {
+	b := sadbDumpResponse
+	f, err := NewFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ParseMsg(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Header() != expected.Msg {
+		t.Errorf("Expected header %+v but got %+v instead", expected.Msg, f.Header())
+	}
+
+	offset := SADBMSG_LEN * WORD_SIZE
+	count := 0
+	it := f.Extensions()
+	for it.Next() {
+		ext := it.Bytes()
+		if &ext[0] != &b[offset] {
+			t.Errorf("Extension %d at offset %d was copied out of the frame", it.Type(), offset)
+		}
+
+		var hdr SADBExt
+		hdr.unmarshal(ext, nativeEndian)
+		if hdr.Type != it.Type() || int(hdr.Len)*WORD_SIZE != len(ext) {
+			t.Errorf("Extension at offset %d doesn't match its header %+v", offset, hdr)
+		}
+
+		offset += len(ext)
+		count++
+	}
+
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if offset != len(b) {
+		t.Errorf("Expected to iterate over %d bytes but stopped at %d", len(b), offset)
+	}
+	if count == 0 {
+		t.Error("Expected to find extensions in the dump message")
+	}
+}
+
+func TestFrameAccessors(t *testing.T) {
+	f, err := NewFrame(sadbDumpResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ParseMsg(sadbDumpResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sa, err := f.SA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa != expected.Extensions.SA {
+		t.Errorf("Expected SA %+v but got %+v instead", expected.Extensions.SA, sa)
+	}
+
+	src, err := f.Src()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSrc, _ := expected.Extensions.SockAddrSrc.BuildNode()
+	if !src.Addr.Equal(expectedSrc.Addr) || src.Port != expectedSrc.Port {
+		t.Errorf("Expected source %+v but got %+v instead", expectedSrc, src)
+	}
+
+	dst, err := f.Dst()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedDst, _ := expected.Extensions.SockAddrDst.BuildNode()
+	if !dst.Addr.Equal(expectedDst.Addr) || dst.Port != expectedDst.Port {
+		t.Errorf("Expected destination %+v but got %+v instead", expectedDst, dst)
+	}
+
+	if _, err = f.Extension(SADB_X_EXT_KMADDRESS); err != ErrExtensionNotPresent {
+		t.Errorf("Expected %v but got %v instead", ErrExtensionNotPresent, err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		f.SA()
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations when reading the SA but got %v", allocs)
+	}
+}
+
+func TestFrameMalformed(t *testing.T) {
+	if _, err := NewFrame(nil); err != io.EOF {
+		t.Errorf("Expected %v but got %v instead", io.EOF, err)
+	}
+
+	if _, err := NewFrame([]byte{2, 10, 0, 3}); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %v but got %v instead", io.ErrUnexpectedEOF, err)
+	}
+
+	// The SA extension claims to be longer than the message
+	b := []byte{2, 10, 0, 3, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 0, 0, 0, 0, 0}
+	f, err := NewFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := f.Extensions()
+	if it.Next() {
+		t.Error("Expected the iteration to stop on a truncated extension")
+	}
+	if it.Err() != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %v but got %v instead", io.ErrUnexpectedEOF, it.Err())
+	}
+
+	if _, err = f.SA(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %v but got %v instead", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func BenchmarkFrameSA(b *testing.B) {
+	msg := sadbDumpResponse
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f, err := NewFrame(msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = f.SA(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
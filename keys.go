@@ -0,0 +1,96 @@
+package pfkey
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Keys holds the randomly generated keys for an SA, see GenerateKeys.
+type Keys struct {
+	EncryptAlg uint8
+	EncryptKey []byte
+	// Salt is only set for algorithms that need one, it goes at the end of the encryption key
+	Salt []byte
+	// EncryptBits is the size of EncryptKeyWithSalt, which is what SetEncryptKey takes. It includes the salt,
+	// so it's larger than EncryptKey for the algorithms that need one
+	EncryptBits int
+	AuthAlg     uint8
+	AuthKey     []byte
+	// AuthBits is the size of the authentication key to pass to SetAuthKey
+	AuthBits int
+}
+
+// GenerateKeys returns random keys for an SA using the encryption algorithm encAlg (SADB_EALG_NONE for none)
+// and the authentication algorithm authAlg (SADB_AALG_NONE for none). The largest key size valid for each
+// algorithm is used. It returns an error for algorithms missing from the registry, or if encAlg is an AEAD
+// algorithm and authAlg isn't SADB_AALG_NONE.
+func GenerateKeys(encAlg uint8, authAlg uint8) (Keys, error) {
+	k := Keys{
+		EncryptAlg: encAlg,
+		AuthAlg:    authAlg,
+	}
+
+	if encAlg != SADB_EALG_NONE {
+		a, ok := lookupAlgorithmID(encAlg, true)
+		if !ok {
+			return Keys{}, fmt.Errorf("unknown encryption algorithm %d", encAlg)
+		}
+		if a.AEAD && authAlg != SADB_AALG_NONE {
+			return Keys{}, fmt.Errorf("%s is an AEAD algorithm and can't be combined with an authentication algorithm", a.Name)
+		}
+
+		k.EncryptBits = a.KeyBits[len(a.KeyBits)-1]
+		key, err := randomBytes(k.EncryptBits / 8)
+		if err != nil {
+			return Keys{}, err
+		}
+		n := len(key) - a.SaltLen
+		k.EncryptKey = key[:n:n]
+		if a.SaltLen != 0 {
+			k.Salt = key[n:]
+		}
+	}
+
+	if authAlg != SADB_AALG_NONE {
+		a, ok := lookupAlgorithmID(authAlg, false)
+		if !ok {
+			return Keys{}, fmt.Errorf("unknown authentication algorithm %d", authAlg)
+		}
+
+		k.AuthBits = a.KeyBits[len(a.KeyBits)-1]
+		key, err := randomBytes(k.AuthBits / 8)
+		if err != nil {
+			return Keys{}, err
+		}
+		k.AuthKey = key
+	}
+
+	return k, nil
+}
+
+// EncryptKeyWithSalt returns the encryption key with its salt appended, as the kernel expects it. Pass it to
+// SetEncryptKey along with EncryptBits when building messages by hand, SetKeys takes care of it for SAConfig.
+func (k Keys) EncryptKeyWithSalt() []byte {
+	if len(k.Salt) == 0 {
+		return k.EncryptKey
+	}
+	key := make([]byte, 0, len(k.EncryptKey)+len(k.Salt))
+	return append(append(key, k.EncryptKey...), k.Salt...)
+}
+
+// SetKeys sets the algorithms, keys and salt of this SAConfig from k.
+func (cfg *SAConfig) SetKeys(k Keys) {
+	cfg.EncryptAlg = k.EncryptAlg
+	cfg.EncryptKey = k.EncryptKey
+	cfg.Salt = k.Salt
+	cfg.AuthAlg = k.AuthAlg
+	cfg.AuthKey = k.AuthKey
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,111 @@
+package pfkey
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func TestGenerateKeys(t *testing.T) {
+	k, err := GenerateKeys(SADB_X_EALG_AESCBC, SADB_X_AALG_SHA2_256HMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.EncryptKey) != 32 || k.EncryptBits != 256 || k.Salt != nil {
+		t.Errorf("Unexpected AES-CBC key %+v", k)
+	}
+	if len(k.AuthKey) != 32 || k.AuthBits != 256 {
+		t.Errorf("Unexpected HMAC-SHA2-256 key %+v", k)
+	}
+
+	other, err := GenerateKeys(SADB_X_EALG_AESCBC, SADB_X_AALG_SHA2_256HMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(k.EncryptKey, other.EncryptKey) || bytes.Equal(k.AuthKey, other.AuthKey) {
+		t.Error("Expected different keys on every call")
+	}
+
+	k, err = GenerateKeys(SADB_X_EALG_AES_GCM_ICV16, SADB_AALG_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.EncryptKey) != 32 || len(k.Salt) != 4 || k.EncryptBits != 288 || k.AuthKey != nil {
+		t.Errorf("Unexpected AES-GCM key %+v", k)
+	}
+
+	if _, err = GenerateKeys(SADB_X_EALG_AES_GCM_ICV16, SADB_X_AALG_SHA2_256HMAC); err == nil {
+		t.Error("Expected an AEAD algorithm combined with authentication to be rejected")
+	}
+	if _, err = GenerateKeys(200, SADB_AALG_NONE); err == nil {
+		t.Error("Expected an unknown encryption algorithm to be rejected")
+	}
+	if _, err = GenerateKeys(SADB_EALG_NONE, 200); err == nil {
+		t.Error("Expected an unknown authentication algorithm to be rejected")
+	}
+}
+
+func TestGenerateKeysBuildSA(t *testing.T) {
+	for _, algs := range [][2]uint8{
+		{SADB_X_EALG_AESCBC, SADB_X_AALG_SHA2_512HMAC},
+		{SADB_X_EALG_AESCTR, SADB_AALG_SHA1HMAC},
+		{SADB_X_EALG_AES_CCM_ICV8, SADB_AALG_NONE},
+		{SADB_EALG_NULL, SADB_X_AALG_AES_XCBC_MAC},
+	} {
+		k, err := GenerateKeys(algs[0], algs[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := SAConfig{
+			Src: netip.MustParseAddrPort("10.0.0.1:0"),
+			Dst: netip.MustParseAddrPort("10.0.0.2:0"),
+		}
+		cfg.SetKeys(k)
+
+		msg, err := BuildSA(cfg)
+		if err != nil {
+			t.Errorf("Unexpected error building SA with algorithms %v: %v", algs, err)
+			continue
+		}
+		if int(msg.Extensions.EncryptKey.Bits) != k.EncryptBits || int(msg.Extensions.AuthKey.Bits) != k.AuthBits {
+			t.Errorf("Expected key sizes of %d and %d bits but got %+v and %+v", k.EncryptBits, k.AuthBits, msg.Extensions.EncryptKey, msg.Extensions.AuthKey)
+		}
+	}
+}
+
+func TestKeysSetEncryptKey(t *testing.T) {
+	k, err := GenerateKeys(SADB_X_EALG_AES_GCM_ICV16, SADB_AALG_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := Msg{}
+	msg.SetEncryptKey(k.EncryptKeyWithSalt(), k.EncryptBits)
+
+	// The salt has to end up after the key, not replaced by zero padding
+	if msg.Extensions.EncryptKey.Bits != 288 || !bytes.Equal(msg.Extensions.EncryptKeyBits[:32], k.EncryptKey) || !bytes.Equal(msg.Extensions.EncryptKeyBits[32:36], k.Salt) {
+		t.Errorf("Unexpected encryption key %+v %x for %+v", msg.Extensions.EncryptKey, msg.Extensions.EncryptKeyBits, k)
+	}
+
+	cfg := SAConfig{
+		Src: netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst: netip.MustParseAddrPort("10.0.0.2:0"),
+	}
+	cfg.SetKeys(k)
+	built, err := BuildSA(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Extensions.EncryptKey != msg.Extensions.EncryptKey || !bytes.Equal(built.Extensions.EncryptKeyBits, msg.Extensions.EncryptKeyBits) {
+		t.Errorf("Expected BuildSA to send the same key as SetEncryptKey but got %+v %x", built.Extensions.EncryptKey, built.Extensions.EncryptKeyBits)
+	}
+
+	k, err = GenerateKeys(SADB_X_EALG_AESCBC, SADB_AALG_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k.EncryptKeyWithSalt(), k.EncryptKey) {
+		t.Error("Expected the key to be unchanged for algorithms without a salt")
+	}
+}
@@ -0,0 +1,100 @@
+package pfkey
+
+import (
+	"time"
+)
+
+// Lifetime describes the soft or hard limits of an SA. The SA expires as soon as any of its non-zero limits is reached.
+type Lifetime struct {
+	Allocations uint32
+	Bytes       uint64
+	// AddTime is measured from the moment the SA was added and UseTime from its first use
+	AddTime time.Duration
+	UseTime time.Duration
+}
+
+// Usage describes how much an SA has been used so far, as reported in its current lifetime.
+type Usage struct {
+	Allocations uint32
+	Bytes       uint64
+	AddedAt     time.Time
+	// FirstUsedAt is the zero Time if the SA hasn't been used yet
+	FirstUsedAt time.Time
+}
+
+// sadbLifetime returns the sadb_lifetime struct for this Lifetime. Durations are rounded up to the
+// next second, so that a short non-zero limit isn't mistaken for no limit at all.
+func (l Lifetime) sadbLifetime() SADBLifetime {
+	return SADBLifetime{
+		Allocations: l.Allocations,
+		Bytes:       l.Bytes,
+		Addtime:     durationSeconds(l.AddTime),
+		Usetime:     durationSeconds(l.UseTime),
+	}
+}
+
+func durationSeconds(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64((d + time.Second - 1) / time.Second)
+}
+
+// newLifetime builds a Lifetime out of a soft or hard sadb_lifetime, where times are in seconds.
+func newLifetime(lt SADBLifetime) Lifetime {
+	return Lifetime{
+		Allocations: lt.Allocations,
+		Bytes:       lt.Bytes,
+		AddTime:     time.Duration(lt.Addtime) * time.Second,
+		UseTime:     time.Duration(lt.Usetime) * time.Second,
+	}
+}
+
+// newUsage builds a Usage out of a current sadb_lifetime, where times are Unix timestamps.
+func newUsage(lt SADBLifetime) Usage {
+	u := Usage{
+		Allocations: lt.Allocations,
+		Bytes:       lt.Bytes,
+		AddedAt:     time.Unix(int64(lt.Addtime), 0),
+	}
+	if lt.Usetime != 0 {
+		u.FirstUsedAt = time.Unix(int64(lt.Usetime), 0)
+	}
+	return u
+}
+
+// SetSoftLifetimeDuration sets the LifetimeSoft extension on this PFKEYMsg from l. Unlike SetLifetimeSoft,
+// its times are durations instead of seconds.
+func (p *Msg) SetSoftLifetimeDuration(l Lifetime) {
+	p.SetLifetimeSoft(l.sadbLifetime())
+}
+
+// SetHardLifetimeDuration sets the LifetimeHard extension on this PFKEYMsg from l. Unlike SetLifetimeHard,
+// its times are durations instead of seconds.
+func (p *Msg) SetHardLifetimeDuration(l Lifetime) {
+	p.SetLifetimeHard(l.sadbLifetime())
+}
+
+// SoftLifetime returns the LifetimeSoft extension of this PFKEYMsg as a Lifetime.
+func (p *Msg) SoftLifetime() (Lifetime, error) {
+	if !p.HasLifetimeSoft() {
+		return Lifetime{}, ErrExtensionNotPresent
+	}
+	return newLifetime(p.Extensions.LifetimeSoft), nil
+}
+
+// HardLifetime returns the LifetimeHard extension of this PFKEYMsg as a Lifetime.
+func (p *Msg) HardLifetime() (Lifetime, error) {
+	if !p.HasLifetimeHard() {
+		return Lifetime{}, ErrExtensionNotPresent
+	}
+	return newLifetime(p.Extensions.LifetimeHard), nil
+}
+
+// Usage returns the LifetimeCurrent extension of this PFKEYMsg as a Usage.
+func (p *Msg) Usage() (Usage, error) {
+	if !p.HasLifetimeCurrent() {
+		return Usage{}, ErrExtensionNotPresent
+	}
+	return newUsage(p.Extensions.LifetimeCurrent), nil
+}
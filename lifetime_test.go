@@ -0,0 +1,71 @@
+package pfkey
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestLifetimeRoundTrip(t *testing.T) {
+	msg := Msg{Msg: SADBMsg{Version: PF_KEY_V2, Type: SADB_GET, SAType: SADB_SATYPE_ESP}}
+	msg.SetSoftLifetimeDuration(Lifetime{Bytes: 1 << 20, AddTime: 1500 * time.Millisecond})
+	msg.SetHardLifetimeDuration(Lifetime{Allocations: 10, UseTime: time.Hour})
+	msg.SetLifetimeCurrent(SADBLifetime{Bytes: 512, Addtime: 1700000000, Usetime: 1700000042})
+
+	// Durations are sent in seconds, rounding up
+	if msg.Extensions.LifetimeSoft.Addtime != 2 || msg.Extensions.LifetimeHard.Usetime != 3600 {
+		t.Errorf("Unexpected lifetimes %+v and %+v", msg.Extensions.LifetimeSoft, msg.Extensions.LifetimeHard)
+	}
+
+	received := roundTripMsg(t, msg)
+
+	soft, err := received.SoftLifetime()
+	if err != nil || soft != (Lifetime{Bytes: 1 << 20, AddTime: 2 * time.Second}) {
+		t.Errorf("Unexpected soft lifetime %+v: %v", soft, err)
+	}
+
+	hard, err := received.HardLifetime()
+	if err != nil || hard != (Lifetime{Allocations: 10, UseTime: time.Hour}) {
+		t.Errorf("Unexpected hard lifetime %+v: %v", hard, err)
+	}
+
+	usage, err := received.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Bytes != 512 || !usage.AddedAt.Equal(time.Unix(1700000000, 0)) || !usage.FirstUsedAt.Equal(time.Unix(1700000042, 0)) {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	received.Present.LifetimeCurrent = false
+	if _, err = received.Usage(); err != ErrExtensionNotPresent {
+		t.Errorf("Expected ErrExtensionNotPresent but got %v instead", err)
+	}
+}
+
+func TestDecodeSALifetimes(t *testing.T) {
+	msg, err := BuildSA(SAConfig{
+		Src:          netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst:          netip.MustParseAddrPort("10.0.0.2:0"),
+		EncryptAlg:   SADB_X_EALG_AESCBC,
+		EncryptKey:   make([]byte, 16),
+		SoftLifetime: Lifetime{AddTime: time.Minute},
+		HardLifetime: Lifetime{AddTime: 2 * time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.SetLifetimeCurrent(SADBLifetime{Addtime: 1700000000})
+
+	info, err := msg.DecodeSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.SoftLifetime.AddTime != time.Minute || info.HardLifetime.AddTime != 2*time.Minute {
+		t.Errorf("Unexpected lifetimes in decoded SA %+v", info)
+	}
+	if !info.Usage.AddedAt.Equal(time.Unix(1700000000, 0)) || !info.Usage.FirstUsedAt.IsZero() {
+		t.Errorf("Unexpected usage in decoded SA %+v", info.Usage)
+	}
+}
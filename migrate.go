@@ -0,0 +1,135 @@
+package pfkey
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// IPSecRequestPair holds the old and new IPsec requests for a SA that's being migrated.
+type IPSecRequestPair struct {
+	Old IPSecRequest
+	New IPSecRequest
+}
+
+// KMAddress holds the local and remote addresses of the key manager.
+type KMAddress struct {
+	Local  Node
+	Remote Node
+}
+
+// Migration holds the information carried by a SADB_X_MIGRATE message.
+type Migration struct {
+	// Src and Dst are the selector of the policy being migrated
+	Src Selector
+	Dst Selector
+	Dir uint8
+	// KMAddress is nil if the message didn't include a SADB_X_EXT_KMADDRESS extension
+	KMAddress *KMAddress
+	Pairs     []IPSecRequestPair
+}
+
+// BuildSADBXMIGRATE builds a SADB_X_MIGRATE message, moving the SAs and policy matching the selector src/dst
+// (including their prefix lengths and protocols) and direction dir from the old endpoints of each pair to the new ones.
+// Every request in pairs needs to have its tunnel endpoints set, as the kernel uses them to find the SAs to migrate.
+// The SADB_X_EXT_KMADDRESS extension is optional and only added when km isn't nil.
+func BuildSADBXMIGRATE(seq uint32, src Selector, dst Selector, dir uint8, km *KMAddress, pairs []IPSecRequestPair) (*Msg, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("SADB_X_MIGRATE needs at least one pair of IPsec requests")
+	}
+
+	if !src.Prefix.IsValid() || !dst.Prefix.IsValid() {
+		return nil, fmt.Errorf("invalid selector %s -> %s", src.Prefix, dst.Prefix)
+	}
+
+	if src.Prefix.Addr().Is6() != dst.Prefix.Addr().Is6() {
+		return nil, fmt.Errorf("source %s and destination %s belong to different address families", src.Prefix, dst.Prefix)
+	}
+
+	requests := make([]IPSecRequest, 0, 2*len(pairs))
+	for i, pair := range pairs {
+		if !pair.Old.HasTunnel() || !pair.New.HasTunnel() {
+			return nil, fmt.Errorf("IPsec request pair %d is missing its endpoints", i)
+		}
+		requests = append(requests, pair.Old, pair.New)
+	}
+
+	p := &Msg{}
+
+	p.Msg = SADBMsg{
+		Type:   SADB_X_MIGRATE,
+		SAType: SADB_SATYPE_ESP,
+		Seq:    seq,
+		PID:    uint32(os.Getpid()),
+	}
+
+	p.SetSelectorSrc(src)
+	p.SetSelectorDst(dst)
+
+	p.SetXPolicy(SADBXPolicy{
+		Type: IPSEC_POLICY_IPSEC,
+		Dir:  dir,
+	}, requests)
+
+	if km != nil {
+		p.SetKMAddress(km.Local, km.Remote)
+	}
+
+	p.setMsgLen()
+
+	return p, nil
+}
+
+// DecodeMigrate decodes a SADB_X_MIGRATE message into a Migration.
+func (p *Msg) DecodeMigrate() (Migration, error) {
+	var m Migration
+
+	if p.Msg.Type != SADB_X_MIGRATE {
+		return m, fmt.Errorf("unexpected message type %d, expected SADB_X_MIGRATE", p.Msg.Type)
+	}
+
+	if !p.HasAddressSrc() || !p.HasAddressDst() || !p.HasXPolicy() {
+		return m, errors.New("SADB_X_MIGRATE message is missing required extensions")
+	}
+
+	requests := p.Extensions.XPolicyRequests
+	if len(requests) == 0 || len(requests)%2 != 0 {
+		return m, fmt.Errorf("SADB_X_MIGRATE message has %d IPsec requests, expected a non-zero even number", len(requests))
+	}
+
+	var err error
+	m.Src, err = p.SelectorSrc()
+	if err != nil {
+		return m, err
+	}
+
+	m.Dst, err = p.SelectorDst()
+	if err != nil {
+		return m, err
+	}
+
+	m.Dir = p.Extensions.XPolicy.Dir
+
+	if p.HasKMAddress() {
+		var km KMAddress
+		km.Local, err = p.Extensions.SockAddrKMLocal.BuildNode()
+		if err != nil {
+			return m, err
+		}
+
+		km.Remote, err = p.Extensions.SockAddrKMRemote.BuildNode()
+		if err != nil {
+			return m, err
+		}
+		m.KMAddress = &km
+	}
+
+	for i := 0; i < len(requests); i += 2 {
+		m.Pairs = append(m.Pairs, IPSecRequestPair{
+			Old: requests[i],
+			New: requests[i+1],
+		})
+	}
+
+	return m, nil
+}
@@ -0,0 +1,141 @@
+package pfkey
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func buildMigrationPair(oldSrc, oldDst, newSrc, newDst net.IP) IPSecRequestPair {
+	oldReq := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 7)
+	oldReq.SetTunnel(Node{Addr: oldSrc}, Node{Addr: oldDst})
+
+	newReq := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 7)
+	newReq.SetTunnel(Node{Addr: newSrc}, Node{Addr: newDst})
+
+	return IPSecRequestPair{Old: oldReq, New: newReq}
+}
+
+func TestBuildSADBXMIGRATE(t *testing.T) {
+	src := Selector{Prefix: netip.MustParsePrefix("10.1.0.0/16"), Proto: IPSEC_ULPROTO_ANY}
+	dst := Selector{Prefix: netip.MustParsePrefix("10.2.0.0/16"), Port: 443, Proto: unix.IPPROTO_TCP}
+	km := KMAddress{
+		Local:  Node{Addr: net.IPv4(192, 168, 1, 1)},
+		Remote: Node{Addr: net.IPv4(198, 51, 100, 1)},
+	}
+	pair := buildMigrationPair(net.IPv4(192, 168, 0, 1), net.IPv4(198, 51, 100, 1), net.IPv4(192, 168, 1, 1), net.IPv4(198, 51, 100, 1))
+
+	msg, err := BuildSADBXMIGRATE(42, src, dst, IPSEC_DIR_OUTBOUND, &km, []IPSecRequestPair{pair})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 words of sadb_x_policy plus two 48 byte requests
+	if msg.Extensions.XPolicy.Len != 14 {
+		t.Errorf("Expected policy length 14 but got %d instead", msg.Extensions.XPolicy.Len)
+	}
+
+	// msg + src + dst + policy + kmaddress
+	if msg.Msg.Len != 2+3+3+14+5 {
+		t.Errorf("Expected message length %d but got %d instead", 2+3+3+14+5, msg.Msg.Len)
+	}
+
+	received := roundTripMsg(t, *msg)
+
+	m, err := received.DecodeMigrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Dir != IPSEC_DIR_OUTBOUND || m.Src != src || m.Dst != dst {
+		t.Errorf("Unexpected selector in decoded migration: %+v", m)
+	}
+
+	if m.KMAddress == nil || !m.KMAddress.Local.Addr.Equal(km.Local.Addr) || !m.KMAddress.Remote.Addr.Equal(km.Remote.Addr) {
+		t.Errorf("Unexpected KMAddress in decoded migration: %+v", m.KMAddress)
+	}
+
+	if len(m.Pairs) != 1 {
+		t.Fatalf("Expected 1 pair of requests but got %d instead", len(m.Pairs))
+	}
+
+	if m.Pairs[0] != pair {
+		t.Errorf("Expected pair %+v but got %+v instead", pair, m.Pairs[0])
+	}
+
+	newSrc, _, err := m.Pairs[0].New.Tunnel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newSrc.Addr.Equal(net.IPv4(192, 168, 1, 1)) {
+		t.Errorf("Unexpected new tunnel source %+v", newSrc)
+	}
+}
+
+func TestBuildSADBXMIGRATEWithoutKMAddress(t *testing.T) {
+	src := Selector{Prefix: netip.MustParsePrefix("10.1.0.0/16"), Proto: IPSEC_ULPROTO_ANY}
+	dst := Selector{Prefix: netip.MustParsePrefix("10.2.0.0/16"), Proto: IPSEC_ULPROTO_ANY}
+	pair := buildMigrationPair(net.IPv4(192, 168, 0, 1), net.IPv4(198, 51, 100, 1), net.IPv4(192, 168, 1, 1), net.IPv4(198, 51, 100, 1))
+
+	msg, err := BuildSADBXMIGRATE(42, src, dst, IPSEC_DIR_OUTBOUND, nil, []IPSecRequestPair{pair})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// msg + src + dst + policy
+	if msg.HasKMAddress() || msg.Msg.Len != 2+3+3+14 {
+		t.Errorf("Expected a message of length %d without a KMAddress but got %+v", 2+3+3+14, msg)
+	}
+
+	received := roundTripMsg(t, *msg)
+
+	m, err := received.DecodeMigrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.KMAddress != nil {
+		t.Errorf("Expected no KMAddress in decoded migration but got %+v", m.KMAddress)
+	}
+}
+
+func TestBuildSADBXMIGRATEWithoutEndpoints(t *testing.T) {
+	src := Selector{Prefix: netip.MustParsePrefix("10.1.0.0/16"), Proto: IPSEC_ULPROTO_ANY}
+	dst := Selector{Prefix: netip.MustParsePrefix("10.2.0.0/16"), Proto: IPSEC_ULPROTO_ANY}
+	pair := IPSecRequestPair{
+		Old: NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TRANSPORT, IPSEC_LEVEL_REQUIRE, 0),
+		New: NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TRANSPORT, IPSEC_LEVEL_REQUIRE, 0),
+	}
+
+	if _, err := BuildSADBXMIGRATE(42, src, dst, IPSEC_DIR_OUTBOUND, nil, []IPSecRequestPair{pair}); err == nil {
+		t.Error("Expected an error when migrating requests without endpoints")
+	}
+
+	if _, err := BuildSADBXMIGRATE(42, src, dst, IPSEC_DIR_OUTBOUND, nil, nil); err == nil {
+		t.Error("Expected an error when migrating without any requests")
+	}
+}
+
+func TestBuildSADBXMIGRATEMixedFamilies(t *testing.T) {
+	src := Selector{Prefix: netip.MustParsePrefix("10.1.0.0/16"), Proto: IPSEC_ULPROTO_ANY}
+	dst := Selector{Prefix: netip.MustParsePrefix("2001:db8::/64"), Proto: IPSEC_ULPROTO_ANY}
+	pair := buildMigrationPair(net.IPv4(192, 168, 0, 1), net.IPv4(198, 51, 100, 1), net.IPv4(192, 168, 1, 1), net.IPv4(198, 51, 100, 1))
+
+	if _, err := BuildSADBXMIGRATE(42, src, dst, IPSEC_DIR_OUTBOUND, nil, []IPSecRequestPair{pair}); err == nil {
+		t.Error("Expected an error when migrating a selector with mixed address families")
+	}
+}
+
+func TestDecodeMigrateOddRequests(t *testing.T) {
+	msg := Msg{Msg: SADBMsg{Type: SADB_X_MIGRATE}}
+	msg.SetAddressSrc(Node{Addr: net.IPv4(10, 1, 0, 0)})
+	msg.SetAddressDst(Node{Addr: net.IPv4(10, 2, 0, 0)})
+	msg.SetXPolicy(SADBXPolicy{Type: IPSEC_POLICY_IPSEC}, []IPSecRequest{
+		NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 0),
+	})
+
+	if _, err := msg.DecodeMigrate(); err == nil {
+		t.Error("Expected an error when decoding a migration with unpaired requests")
+	}
+}
@@ -2,7 +2,9 @@ package pfkey
 
 import (
 	"net"
+	"net/netip"
 	"os"
+	"reflect"
 	"testing"
 
 	"golang.org/x/sys/unix"
@@ -191,3 +193,288 @@ func TestSPI(t *testing.T) {
 
 	}
 }
+
+func TestBuildSADBADDWithSA2(t *testing.T) {
+	src := Node{Addr: net.IPv4(1, 2, 3, 4)}
+	dst := Node{Addr: net.IPv4(5, 6, 7, 8)}
+	encryptKeyBits := expectedAddMsg.Extensions.EncryptKeyBits
+
+	msg, err := BuildSADBADD(1337, 31337, src, dst, encryptKeyBits, WithSA2(IPSEC_MODE_TUNNEL, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := expectedAddMsg
+	expected.Msg.Len += SADBXSA2_LEN
+	expected.Present.SA2 = true
+	expected.Extensions.SA2 = SADBXSA2{
+		Len:     SADBXSA2_LEN,
+		ExtType: SADB_X_EXT_SA2,
+		Mode:    IPSEC_MODE_TUNNEL,
+		ReqID:   42,
+	}
+
+	if err = compareMessages(expected, *msg); err != nil {
+		t.Error(err)
+	}
+
+	received := roundTripMsg(t, *msg)
+	if !received.HasSA2() || received.Extensions.SA2 != expected.Extensions.SA2 {
+		t.Errorf("Expected SA2 %+v but got %+v instead", expected.Extensions.SA2, received.Extensions.SA2)
+	}
+}
+
+func TestBuildSADBADDWithNATT(t *testing.T) {
+	src := Node{Addr: net.IPv4(1, 2, 3, 4)}
+	dst := Node{Addr: net.IPv4(5, 6, 7, 8)}
+	oa := Node{Addr: net.IPv4(192, 168, 1, 10)}
+	encryptKeyBits := expectedAddMsg.Extensions.EncryptKeyBits
+
+	msg, err := BuildSADBADD(1337, 31337, src, dst, encryptKeyBits, WithNATT(UDP_ENCAP_ESPINUDP, 4500, 4501), WithNATTOA(oa))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedLen := expectedAddMsg.Msg.Len + SADBXNATTTYPE_LEN + 2*SADBXNATTPORT_LEN + SADBADDRESS_LEN + SOCKADDRIN_LEN
+	if msg.Msg.Len != expectedLen {
+		t.Errorf("Expected message length %d but got %d instead", expectedLen, msg.Msg.Len)
+	}
+
+	received := roundTripMsg(t, *msg)
+
+	if !received.HasNATTType() || received.Extensions.NATTType.Type != UDP_ENCAP_ESPINUDP {
+		t.Errorf("Unexpected NAT-T type %+v", received.Extensions.NATTType)
+	}
+
+	if port := received.Extensions.NATTSport.GetPort(); !received.HasNATTSport() || port != 4500 {
+		t.Errorf("Expected NAT-T source port 4500 but got %d instead", port)
+	}
+
+	if port := received.Extensions.NATTDport.GetPort(); !received.HasNATTDport() || port != 4501 {
+		t.Errorf("Expected NAT-T destination port 4501 but got %d instead", port)
+	}
+
+	if !received.HasNATTOA() || received.Extensions.SockAddrNATTOA.(sockAddrIn).SinAddr != [4]byte{192, 168, 1, 10} {
+		t.Errorf("Unexpected NAT-T original address %+v", received.Extensions.SockAddrNATTOA)
+	}
+}
+
+func TestNATTPortByteOrder(t *testing.T) {
+	m := Msg{}
+	m.SetNATTSport(4500)
+
+	b, err := getBytes(m.Extensions.NATTSport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The port must end up in network order on the wire
+	if b[4] != 0x11 || b[5] != 0x94 {
+		t.Errorf("Expected port in network order but got %+v", b[4:6])
+	}
+}
+
+func TestInvalidNATTType(t *testing.T) {
+	src := Node{Addr: net.IPv4(1, 2, 3, 4)}
+	dst := Node{Addr: net.IPv4(5, 6, 7, 8)}
+
+	_, err := BuildSADBADD(1337, 31337, src, dst, expectedAddMsg.Extensions.EncryptKeyBits, WithNATT(42, 4500, 4500))
+	if err == nil {
+		t.Error("Expected an error when using an invalid NAT-T encapsulation type")
+	}
+}
+
+func TestNATTOnlyForESP(t *testing.T) {
+	cfg := SAConfig{
+		SAType:  SADB_SATYPE_AH,
+		Src:     netip.MustParseAddrPort("1.2.3.4:0"),
+		Dst:     netip.MustParseAddrPort("5.6.7.8:0"),
+		AuthAlg: SADB_X_AALG_SHA2_256HMAC,
+		AuthKey: make([]byte, 32),
+		Options: []SAOption{WithNATT(UDP_ENCAP_ESPINUDP, 4500, 4500)},
+	}
+
+	if _, err := BuildSA(cfg); err == nil {
+		t.Error("Expected an error when requesting NAT-T encapsulation for an AH SA")
+	}
+}
+
+func TestBuildSADBADDWithSecCtx(t *testing.T) {
+	src := Node{Addr: net.IPv4(1, 2, 3, 4)}
+	dst := Node{Addr: net.IPv4(5, 6, 7, 8)}
+	ctx := "system_u:object_r:ipsec_spd_t:s0"
+
+	msg, err := BuildSADBADD(1337, 31337, src, dst, expectedAddMsg.Extensions.EncryptKeyBits, WithSecCtx(XFRM_SC_DOI_LSM, XFRM_SC_ALG_SELINUX, ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 word of header plus 32 bytes of context
+	expected := SADBXSecCtx{Len: 5, ExtType: SADB_X_EXT_SEC_CTX, CtxDOI: XFRM_SC_DOI_LSM, CtxAlg: XFRM_SC_ALG_SELINUX, CtxLen: 32}
+	if msg.Extensions.SecCtx != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, msg.Extensions.SecCtx)
+	}
+
+	received := roundTripMsg(t, *msg)
+	if !received.HasSecCtx() || received.Extensions.SecCtx != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, received.Extensions.SecCtx)
+	}
+
+	if string(received.Extensions.SecCtxBits) != ctx {
+		t.Errorf("Expected security context %q but got %q instead", ctx, received.Extensions.SecCtxBits)
+	}
+}
+
+func TestSecCtxPadding(t *testing.T) {
+	msg := BuildSADBFLUSH()
+	msg.SetSecCtx(XFRM_SC_DOI_LSM, XFRM_SC_ALG_SELINUX, []byte("unconfined_t\x00"))
+
+	// 13 bytes of context need to be padded to 16
+	if msg.Extensions.SecCtx.Len != 3 {
+		t.Errorf("Expected extension length 3 but got %d instead", msg.Extensions.SecCtx.Len)
+	}
+
+	received := roundTripMsg(t, msg)
+	if received.Msg.Len != SADBMSG_LEN+3 {
+		t.Errorf("Expected message length %d but got %d instead", SADBMSG_LEN+3, received.Msg.Len)
+	}
+
+	if string(received.Extensions.SecCtxBits) != "unconfined_t\x00" {
+		t.Errorf("Unexpected security context %q", received.Extensions.SecCtxBits)
+	}
+}
+
+func TestInvalidSecCtx(t *testing.T) {
+	src := Node{Addr: net.IPv4(1, 2, 3, 4)}
+	dst := Node{Addr: net.IPv4(5, 6, 7, 8)}
+
+	_, err := BuildSADBADD(1337, 31337, src, dst, expectedAddMsg.Extensions.EncryptKeyBits, WithSecCtx(XFRM_SC_DOI_LSM, XFRM_SC_ALG_SELINUX, ""))
+	if err == nil {
+		t.Error("Expected an error when using an empty security context")
+	}
+
+	_, err = BuildSADBADD(1337, 31337, src, dst, expectedAddMsg.Extensions.EncryptKeyBits, WithSecCtx(XFRM_SC_DOI_LSM, XFRM_SC_ALG_SELINUX, string(make([]byte, maxSecCtxLen+1))))
+	if err == nil {
+		t.Error("Expected an error when using a security context larger than a page")
+	}
+
+	_, err = BuildSADBADD(1337, 31337, src, dst, expectedAddMsg.Extensions.EncryptKeyBits, WithSecCtx(XFRM_SC_DOI_LSM, XFRM_SC_ALG_SELINUX, string(make([]byte, 4096))))
+	if err != nil {
+		t.Errorf("Expected a security context of a full page to be accepted: %v", err)
+	}
+}
+
+func TestSADBCombSize(t *testing.T) {
+	b, err := getBytes(SADBComb{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b) != SADBCOMB_LEN*WORD_SIZE {
+		t.Errorf("Expected sadb_comb to be %d bytes but got %d instead", SADBCOMB_LEN*WORD_SIZE, len(b))
+	}
+}
+
+func TestProposalRoundTrip(t *testing.T) {
+	combs := []SADBComb{
+		{
+			Encrypt:        SADB_X_EALG_AESCBC,
+			Auth:           SADB_X_AALG_SHA2_256HMAC,
+			AuthMinBits:    256,
+			AuthMaxBits:    256,
+			EncryptMinBits: 128,
+			EncryptMaxBits: 256,
+			SoftAddTime:    2880,
+			HardAddtime:    3600,
+		},
+		{
+			Encrypt:        SADB_X_EALG_AES_GCM_ICV16,
+			EncryptMinBits: 160,
+			EncryptMaxBits: 288,
+			HardBytes:      1 << 30,
+		},
+	}
+
+	msg := Msg{
+		Msg: SADBMsg{
+			Type:   SADB_ACQUIRE,
+			SAType: SADB_SATYPE_ESP,
+			Seq:    7,
+		},
+	}
+	msg.SetAddressSrc(Node{Addr: net.IPv4(10, 0, 2, 6)})
+	msg.SetAddressDst(Node{Addr: net.IPv4(10, 0, 2, 7)})
+	msg.SetProposal(32, combs)
+
+	expectedProp := SADBProp{Len: 1 + 2*SADBCOMB_LEN, ExtType: SADB_EXT_PROPOSAL, Replay: 32}
+	if msg.Extensions.Proposal != expectedProp {
+		t.Errorf("Expected %+v but got %+v instead", expectedProp, msg.Extensions.Proposal)
+	}
+
+	received := roundTripMsg(t, msg)
+
+	if !received.HasProposal() || received.Extensions.Proposal != expectedProp {
+		t.Errorf("Expected %+v but got %+v instead", expectedProp, received.Extensions.Proposal)
+	}
+
+	if !reflect.DeepEqual(received.Extensions.ProposalCombs, combs) {
+		t.Errorf("Expected combs %+v but got %+v instead", combs, received.Extensions.ProposalCombs)
+	}
+}
+
+func TestReceiveEmptyProposal(t *testing.T) {
+	received := []byte{
+		2, 6, 0, 3, 3, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0,
+		1, 0, 13, 0, 32, 0, 0, 0,
+	}
+
+	expected := Msg{
+		Msg:     SADBMsg{Version: PF_KEY_V2, Type: SADB_ACQUIRE, SAType: SADB_SATYPE_ESP, Len: 3, Seq: 1},
+		Present: sadbExtensionsChecklist{Proposal: true},
+		Extensions: sadbExtensions{
+			Proposal: SADBProp{Len: 1, ExtType: SADB_EXT_PROPOSAL, Replay: 32},
+		},
+	}
+
+	ReceivedAndExpectMessage(t, "empty_proposal", received, expected)
+}
+
+func TestMalformedAddressFamily(t *testing.T) {
+	// None of these used to return, they terminated the whole process instead.
+	bad := sockAddrIn{SinFamily: 99}
+	if _, err := bad.BuildNode(); err == nil {
+		t.Error("Expected an error when building a Node from an unsupported address family")
+	}
+
+	msg := Msg{Msg: SADBMsg{Version: PF_KEY_V2, Type: SADB_X_NAT_T_NEW_MAPPING, SAType: SADB_SATYPE_ESP}}
+	msg.SetSA(SADBSA{SPI: 1337})
+	msg.SetAddressSrc(Node{Addr: net.IPv4(1, 2, 3, 4)})
+	msg.SetAddressDst(Node{Addr: net.IPv4(5, 6, 7, 8)})
+	msg.SetNATTSport(4500)
+	msg.SetNATTDport(4501)
+	msg.Extensions.SockAddrSrc = sockAddrIn{SinFamily: 99}
+	msg.setMsgLen()
+
+	buf := new(msgBuffer)
+	if err := msg.writeToBuffer(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, writer := net.Pipe()
+	go func() {
+		writer.Write(buf.buf)
+		writer.Close()
+	}()
+
+	p := PFKEY{socket: reader}
+	if _, err := p.ReadMsg(); err == nil {
+		t.Error("Expected an error when reading a message with an unsupported address family")
+	}
+
+	r := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 0)
+	r.SetTunnel(Node{Addr: net.IPv4(1, 2, 3, 4)}, Node{Addr: net.IPv4(5, 6, 7, 8)})
+	r.SockAddrDst = sockAddrIn{SinFamily: 99}
+	if _, _, err := r.Tunnel(); err == nil {
+		t.Error("Expected an error when reading a tunnel endpoint with an unsupported address family")
+	}
+}
@@ -1,25 +1,154 @@
 package pfkey
 
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
+	"time"
 
 	"github.com/FranGM/simplelog"
 
 	"golang.org/x/sys/unix"
 )
 
-func (s *SADBMsg) fromBytes(bytesBuf []byte) error {
-	buf := bytes.NewReader(bytesBuf)
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
+// SAOption modifies an SA related message after it has been built, adding optional extensions to it.
+type SAOption func(*Msg) error
+
+// WithSA2 adds a SADB_X_EXT_SA2 extension to the message, setting the IPsec mode (one of IPSEC_MODE_*)
+// and the reqid the SA will be bound to.
+func WithSA2(mode uint8, reqid uint32) SAOption {
+	return func(p *Msg) error {
+		p.SetSA2(SADBXSA2{
+			Mode:  mode,
+			ReqID: reqid,
+		})
+		return nil
+	}
+}
+
+// WithSAType sets the type of SA (one of SADB_SATYPE_*) the message applies to, instead of SADB_SATYPE_ESP.
+// SADB_SATYPE_UNSPEC is only accepted by SADB_DUMP messages, which then dump SAs of every type.
+// SADB_GETSPI messages for IPComp SAs request a 16-bit CPI instead of an SPI.
+func WithSAType(satype uint8) SAOption {
+	return func(p *Msg) error {
+		if satype != SADB_SATYPE_UNSPEC || p.Msg.Type != SADB_DUMP {
+			if err := checkSAType(satype); err != nil {
+				return err
+			}
+		}
+
+		p.Msg.SAType = satype
+		if p.HasSPIRange() {
+			p.setSPIRange()
+		}
+		return nil
+	}
+}
+
+// WithNATT requests UDP encapsulation of ESP packets (NAT traversal) for the SA, using sport and dport
+// (in machine order) as source and destination ports. encapType should be one of the UDP_ENCAP_* constants.
+// The kernel only encapsulates ESP, so it returns an error for any other SA type.
+func WithNATT(encapType uint8, sport uint16, dport uint16) SAOption {
+	return func(p *Msg) error {
+		if p.Msg.SAType != SADB_SATYPE_ESP {
+			return fmt.Errorf("NAT-T encapsulation is only supported for ESP SAs, not SA type %d", p.Msg.SAType)
+		}
+		if encapType != UDP_ENCAP_ESPINUDP && encapType != UDP_ENCAP_ESPINUDP_NON_IKE {
+			return fmt.Errorf("invalid NAT-T encapsulation type: %d", encapType)
+		}
+		p.SetNATTType(encapType)
+		p.SetNATTSport(sport)
+		p.SetNATTDport(dport)
+		return nil
+	}
+}
+
+// WithNATTOA adds the original address of the peer, before it got translated, to the SA.
+func WithNATTOA(oa Node) SAOption {
+	return func(p *Msg) error {
+		p.SetNATTOA(oa)
+		return nil
+	}
+}
+
+// WithSecCtx attaches a security context (for labeled IPsec) to the SA.
+func WithSecCtx(doi uint8, alg uint8, ctx string) SAOption {
+	return func(p *Msg) error {
+		if len(ctx) == 0 {
+			return errors.New("security context can't be empty")
+		}
+		if len(ctx) > maxSecCtxLen {
+			return fmt.Errorf("security context is too long (%d bytes, max is %d)", len(ctx), maxSecCtxLen)
+		}
+		p.SetSecCtx(doi, alg, []byte(ctx))
+		return nil
+	}
+}
+
+// WithAuth adds integrity protection to the SA, using the authentication algorithm alg (one of SADB_AALG_* or SADB_X_AALG_*) with key.
+func WithAuth(alg uint8, key []byte) SAOption {
+	return func(p *Msg) error {
+		if !p.HasSA() {
+			return errors.New("authentication can only be set on messages with an SA extension")
+		}
+		if alg == SADB_AALG_NONE {
+			return errors.New("authentication algorithm can't be SADB_AALG_NONE")
+		}
+		if err := checkKey(alg, false, key); err != nil {
+			return err
+		}
+		p.Extensions.SA.Auth = alg
+		p.SetAuthKey(key, len(key)*8)
+		return nil
+	}
+}
+
+// WithReplayWindow enables anti-replay protection on the SA, with a window of the given size in packets.
+// window needs to fit in the 8-bit sadb_sa_replay field, 0 disables anti-replay.
+func WithReplayWindow(window int) SAOption {
+	return func(p *Msg) error {
+		if !p.HasSA() {
+			return errors.New("replay window can only be set on messages with an SA extension")
+		}
+		if window < 0 || window > maxReplayWindow {
+			return fmt.Errorf("invalid replay window %d, must be between 0 and %d", window, maxReplayWindow)
+		}
+		p.Extensions.SA.Replay = uint8(window)
+		return nil
+	}
 }
 
-// BuildSADBGETSPI builds a SADB_GETSPI message
-func BuildSADBGETSPI(seq uint32, src Node, dst Node) (Msg, error) {
+// WithSAFlags sets flags (a combination of SADB_SAFLAGS_*) on the SA.
+func WithSAFlags(flags uint32) SAOption {
+	return func(p *Msg) error {
+		if !p.HasSA() {
+			return errors.New("SA flags can only be set on messages with an SA extension")
+		}
+		if flags&^saFlagsMask != 0 {
+			return fmt.Errorf("unknown SA flags %#x", flags&^saFlagsMask)
+		}
+		p.Extensions.SA.Flags = flags
+		return nil
+	}
+}
+
+// applyOptions applies all the given options to this PFKEYMsg, stopping at the first error.
+func (p *Msg) applyOptions(opts []SAOption) error {
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildSADBGETSPI builds a SADB_GETSPI message. It requests an ESP SA unless WithSAType is given in opts.
+func BuildSADBGETSPI(seq uint32, src Node, dst Node, opts ...SAOption) (Msg, error) {
+	if err := checkFamilies(src, dst); err != nil {
+		return Msg{}, err
+	}
 
 	msg := Msg{
 		Msg: SADBMsg{
@@ -36,57 +165,143 @@ func BuildSADBGETSPI(seq uint32, src Node, dst Node) (Msg, error) {
 
 	msg.SetAddressSrc(src)
 	msg.SetAddressDst(dst)
+	msg.setSPIRange()
 
-	msg.SetSPIRANGE(spiRangeMin, spiRangeMax)
+	err := msg.applyOptions(opts)
 
-	return msg, nil
+	return msg, err
 }
 
 // SendSADBGETSPI sends a SADB_GETSPI message to the PF_KEY socket.
-func (p *PFKEY) SendSADBGETSPI(seq uint32, src Node, dst Node) error {
-	msg, err := BuildSADBGETSPI(seq, src, dst)
+func (p *PFKEY) SendSADBGETSPI(seq uint32, src Node, dst Node, opts ...SAOption) error {
+	msg, err := BuildSADBGETSPI(seq, src, dst, opts...)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	err = p.SendMsg(msg)
 	return err
 }
 
-// BuildNode generates a Node object pointing to the same host as this sockAddrIn struct
-func (s sockAddrIn) BuildNode() Node {
+// BuildNode generates a Node object pointing to the same host as this sockAddrIn struct.
+// It returns an error if the sockaddr belongs to an address family we don't support.
+func (s sockAddrIn) BuildNode() (Node, error) {
 
 	if s.SinFamily != unix.AF_INET {
-		simplelog.Fatal.Println("Not implemented family in sockaddr")
+		return Node{}, fmt.Errorf("address family %d not implemented in sockaddr", s.SinFamily)
 	}
 
 	n := Node{
 		Addr: net.IPv4(s.SinAddr[0], s.SinAddr[1], s.SinAddr[2], s.SinAddr[3]),
 		Port: s.SinPort,
 	}
-	return n
+	return n, nil
+}
+
+func (s sockAddrIn) family() uint16 {
+	return unix.AF_INET
+}
+
+func (s sockAddrIn) prefixLen() uint8 {
+	return 32
+}
+
+func (s sockAddrIn) addrPort() netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom4(s.SinAddr), networkOrder16(s.SinPort, nativeEndian))
+}
+
+// BuildNode generates a Node object pointing to the same host as this sockAddrIn6 struct.
+// It returns an error if the sockaddr isn't an AF_INET6 one.
+func (s sockAddrIn6) BuildNode() (Node, error) {
+
+	if s.Sin6Family != unix.AF_INET6 {
+		return Node{}, fmt.Errorf("address family %d not implemented in sockaddr_in6", s.Sin6Family)
+	}
+
+	addr := make(net.IP, net.IPv6len)
+	copy(addr, s.Sin6Addr[:])
+
+	n := Node{
+		Addr: addr,
+		Port: s.Sin6Port,
+	}
+	return n, nil
+}
+
+func (s sockAddrIn6) family() uint16 {
+	return unix.AF_INET6
+}
+
+func (s sockAddrIn6) prefixLen() uint8 {
+	return 128
 }
 
-// TODO: This should support setting the family as well
-func (n Node) buildSockAddr() sockAddrIn {
+func (s sockAddrIn6) addrPort() netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom16(s.Sin6Addr), networkOrder16(s.Sin6Port, nativeEndian))
+}
 
-	sAddr := sockAddrIn{
-		SinFamily: unix.AF_INET,
-		SinPort:   n.Port,
-		SinAddr:   n.AddrAsArray(),
+// family returns the address family of this Node: AF_INET6 for IPv6 addresses and AF_INET otherwise.
+func (n Node) family() uint16 {
+	if n.Addr.To4() == nil && n.Addr.To16() != nil {
+		return unix.AF_INET6
+	}
+	return unix.AF_INET
+}
+
+// buildSockAddr builds the sockaddr structure (sockaddr_in or sockaddr_in6) for this Node.
+func (n Node) buildSockAddr() sockAddr {
+	addr, _ := netip.AddrFromSlice(n.Addr)
+	return newSockAddr(addr, networkOrder16(n.Port, nativeEndian))
+}
+
+// newSockAddr builds the sockaddr structure (sockaddr_in or sockaddr_in6) for addr and port (in machine order).
+// An invalid addr is treated as the IPv4 unspecified address.
+func newSockAddr(addr netip.Addr, port uint16) sockAddr {
+	var sAddr sockAddr
+
+	addr = addr.Unmap()
+	if addr.Is6() {
+		sAddr = sockAddrIn6{
+			Sin6Family: unix.AF_INET6,
+			Sin6Port:   networkOrder16(port, nativeEndian),
+			Sin6Addr:   addr.As16(),
+		}
+	} else {
+		var a [4]byte
+		if addr.Is4() {
+			a = addr.As4()
+		}
+		sAddr = sockAddrIn{
+			SinFamily: unix.AF_INET,
+			SinPort:   networkOrder16(port, nativeEndian),
+			SinAddr:   a,
+		}
 	}
 	simplelog.Debug.Printf("Built sockaddr struct: %+v", sAddr)
 
 	return sAddr
 }
 
+// sockAddrPairLen returns the length in bytes of the pair of sockaddrs a and b, padded to a 64-bit boundary.
+func sockAddrPairLen(a sockAddr, b sockAddr) uint16 {
+	n := a.wireSize() + b.wireSize()
+	return uint16((n + WORD_SIZE - 1) / WORD_SIZE * WORD_SIZE)
+}
+
+// checkFamilies returns an error if src and dst don't belong to the same address family.
+func checkFamilies(src Node, dst Node) error {
+	if src.family() != dst.family() {
+		return fmt.Errorf("source %s and destination %s belong to different address families", src.Addr, dst.Addr)
+	}
+	return nil
+}
+
 func (s *SADBKey) setLen(keyBits int) {
 	s.Len = uint16((1 + (keyBits / 8) + 7) / 8)
 }
 
-func extensionNotImplemented(buf *bytes.Buffer, ext SADBExt) {
-	simplelog.Warning.Printf("Extension %d not implemented yet, advancing buffer %d bytes", ext.Type, ext.Len)
-	buf.Next(int(ext.Len * 8))
+func extensionNotImplemented(extType uint16, ext []byte) {
+	simplelog.Warning.Printf("Extension %d not implemented yet, skipping %d bytes", extType, len(ext))
 }
 
 // RetrieveSADBDump listens for a reply to a SADB_DUMP message from the kernel and returns all the relevant SADB_DUMP messages.
@@ -127,7 +342,12 @@ func (p *PFKEY) RetrieveSADBDump() ([]Msg, error) {
 }
 
 // BuildSADBDELETE builds a new SADB_DELETE message for the given spi and nodes.
-func BuildSADBDELETE(spi uint32, src Node, dst Node) (*Msg, error) {
+// It deletes an ESP SA unless WithSAType is given in opts.
+func BuildSADBDELETE(spi uint32, src Node, dst Node, opts ...SAOption) (*Msg, error) {
+	if err := checkFamilies(src, dst); err != nil {
+		return nil, err
+	}
+
 	p := &Msg{}
 
 	p.Msg = SADBMsg{
@@ -143,64 +363,47 @@ func BuildSADBDELETE(spi uint32, src Node, dst Node) (*Msg, error) {
 	p.SetAddressSrc(src)
 	p.SetAddressDst(dst)
 
-	return p, nil
+	err := p.applyOptions(opts)
+
+	return p, err
 }
 
 // BuildSADBUPDATE builds a SADB_UPDATE message to finish establishing a mature association between src and dst.
-func BuildSADBUPDATE(seq uint32, spi uint32, src Node, dst Node, encryptKey []byte) (*Msg, error) {
-	// An ADD message is essentially an UPDATE message with a different Type set, so reuse that.
-	m, err := BuildSADBADD(seq, spi, src, dst, encryptKey)
-	m.Msg.Type = SADB_UPDATE
-
-	return m, err
+// It uses the same defaults as BuildSADBADD, see BuildSA to pick algorithms and lifetimes.
+func BuildSADBUPDATE(seq uint32, spi uint32, src Node, dst Node, encryptKey []byte, opts ...SAOption) (*Msg, error) {
+	cfg := defaultSAConfig(seq, spi, src, dst, encryptKey, opts)
+	cfg.Update = true
+	return BuildSA(cfg)
 }
 
 // BuildSADBADD builds a SADB_ADD message to create a mature association between src and dst.
-func BuildSADBADD(seq uint32, spi uint32, src Node, dst Node, encryptKey []byte) (*Msg, error) {
-	// TODO: We should also do some validation to make sure the message we're building makes sense (valid encryptKey, etc)
-
-	p := &Msg{}
+// Optional extensions (such as SADB_X_EXT_SA2) can be added through opts.
+// The SA uses AES-CBC and expires after 90 seconds. Integrity protection can be added with WithAuth and anti-replay
+// with WithReplayWindow, see BuildSA to pick other algorithms and lifetimes.
+func BuildSADBADD(seq uint32, spi uint32, src Node, dst Node, encryptKey []byte, opts ...SAOption) (*Msg, error) {
+	return BuildSA(defaultSAConfig(seq, spi, src, dst, encryptKey, opts))
+}
 
-	p.Msg = SADBMsg{
-		Type:   SADB_ADD,
-		SAType: SADB_SATYPE_ESP,
-		Seq:    seq,
-		PID:    uint32(os.Getpid()),
+// defaultSAConfig returns the SAConfig used by BuildSADBADD and BuildSADBUPDATE. spi is expected in network order.
+func defaultSAConfig(seq uint32, spi uint32, src Node, dst Node, encryptKey []byte, opts []SAOption) SAConfig {
+	return SAConfig{
+		SAType:       SADB_SATYPE_ESP,
+		Seq:          seq,
+		SPI:          networkOrder32(spi, nativeEndian),
+		Src:          src.AddrPort(),
+		Dst:          dst.AddrPort(),
+		EncryptAlg:   SADB_X_EALG_AESCBC,
+		EncryptKey:   encryptKey,
+		State:        SADB_SASTATE_MATURE,
+		SoftLifetime: Lifetime{AddTime: 60 * time.Second},
+		HardLifetime: Lifetime{AddTime: 90 * time.Second},
+		Options:      opts,
 	}
-
-	p.SetSA(SADBSA{
-		Encrypt: 12,
-		SPI:     spi,
-		State:   SADB_SASTATE_MATURE,
-	})
-
-	// TODO: Make the lifetimes configurable
-	p.SetLifetimeSoft(SADBLifetime{
-		Addtime: 60,
-	})
-
-	p.SetLifetimeHard(SADBLifetime{
-		Addtime: 90,
-	})
-
-	p.SetAddressSrc(src)
-
-	p.SetAddressDst(dst)
-
-	// TODO: Adapt this to the size of encryptKey and do some sanity check depending what encryption algorithm we're using
-	keyBits := 256
-
-	p.SetEncryptKey(encryptKey, keyBits)
-
-	simplelog.Info.Printf("keybits=%d and the actual key is %+v", keyBits, encryptKey)
-
-	p.setMsgLen()
-
-	return p, nil
 }
 
 // BuildSADBREGISTERMsg builds a SADB_REGISTER message that can be sent to the kernel.
-func BuildSADBREGISTERMsg() Msg {
+// Registered sockets receive the SADB_ACQUIRE and SADB_EXPIRE messages for ESP SAs, or for the type given with WithSAType.
+func BuildSADBREGISTERMsg(opts ...SAOption) (Msg, error) {
 
 	msg := Msg{
 		Msg: SADBMsg{
@@ -209,17 +412,41 @@ func BuildSADBREGISTERMsg() Msg {
 			Type:    SADB_REGISTER,
 			SAType:  SADB_SATYPE_ESP,
 			PID:     uint32(os.Getpid())}}
-	return msg
+
+	err := msg.applyOptions(opts)
+	return msg, err
 }
 
 // SendSADBRegisterMsg send a SADB_REGISTER message through this PF_KEY socket.
-func (p *PFKEY) SendSADBRegisterMsg() error {
-	msg := BuildSADBREGISTERMsg()
+func (p *PFKEY) SendSADBRegisterMsg(opts ...SAOption) error {
+	msg, err := BuildSADBREGISTERMsg(opts...)
+	if err != nil {
+		return err
+	}
 
-	err := p.SendMsg(msg)
+	err = p.SendMsg(msg)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// checkSAType returns an error if satype isn't one of the SA types the kernel can hold SAs for.
+func checkSAType(satype uint8) error {
+	switch satype {
+	case SADB_SATYPE_AH, SADB_SATYPE_ESP, SADB_X_SATYPE_IPCOMP:
+		return nil
+	}
+	return fmt.Errorf("unsupported SA type %d", satype)
+}
+
+// setSPIRange sets the SPIRange extension to the SPIs valid for the SA type of this PFKEYMsg.
+// IPComp SAs are identified by a 16-bit CPI instead of an SPI.
+func (p *Msg) setSPIRange() {
+	if p.Msg.SAType == SADB_X_SATYPE_IPCOMP {
+		p.SetSPIRANGE(cpiRangeMin, cpiRangeMax)
+	} else {
+		p.SetSPIRANGE(spiRangeMin, spiRangeMax)
+	}
+}
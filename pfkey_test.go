@@ -151,24 +151,32 @@ func TestReceiveGarbageMsg(t *testing.T) {
 }
 
 func TestBasicMsgParsing(t *testing.T) {
-	messagesFile := filepath.Join("test-fixtures", "messages.json")
-	b, err := ioutil.ReadFile(messagesFile)
+	for k, b := range loadFixtures(t) {
+		ReceivedAndExpectMessage(t, k, b, expectedMessages[k])
+	}
+}
+
+// loadFixtures returns the raw messages in test-fixtures/messages.json, indexed by name.
+func loadFixtures(tb testing.TB) map[string][]byte {
+	b, err := ioutil.ReadFile(filepath.Join("test-fixtures", "messages.json"))
 	if err != nil {
-		t.Fatal(err)
+		tb.Fatal(err)
 	}
 
-	// Load test fixture data
-	var f interface{}
-	err = json.Unmarshal(b, &f)
-	m := f.(map[string]interface{})
+	var m map[string]string
+	if err = json.Unmarshal(b, &m); err != nil {
+		tb.Fatal(err)
+	}
 
+	fixtures := make(map[string][]byte, len(m))
 	for k, v := range m {
-		b, err := base64.RawStdEncoding.DecodeString(v.(string))
+		fixtures[k], err = base64.RawStdEncoding.DecodeString(v)
 		if err != nil {
-			t.Fatal(err)
+			tb.Fatal(err)
 		}
-		ReceivedAndExpectMessage(t, k, b, expectedMessages[k])
 	}
+
+	return fixtures
 }
 
 // TODO: Also create methods that allow us to *send* messages and check for the expected result on the other side.
@@ -2,8 +2,6 @@ package pfkey
 
 import (
 	"fmt"
-
-	"github.com/FranGM/simplelog"
 )
 
 func (p *Msg) String() string {
@@ -34,6 +32,10 @@ func (p *Msg) String() string {
 		s += fmt.Sprintf("%+v %+v", p.Extensions.AddressDst, p.Extensions.SockAddrDst)
 	}
 
+	if p.HasAddressProxy() {
+		s += fmt.Sprintf("%+v %+v", p.Extensions.AddressProxy, p.Extensions.SockAddrProxy)
+	}
+
 	if p.HasAuthKey() {
 		s += fmt.Sprintf("%+v", p.Extensions.AuthKey)
 	}
@@ -42,16 +44,61 @@ func (p *Msg) String() string {
 		s += fmt.Sprintf("%+v", p.Extensions.EncryptKey)
 	}
 
+	if p.HasAuthAlgorithms() {
+		s += fmt.Sprintf("%+v", p.Extensions.AuthAlgorithms)
+	}
+
+	if p.HasEncryptAlgorithms() {
+		s += fmt.Sprintf("%+v", p.Extensions.EncryptAlgorithms)
+	}
+
 	if p.HasSPIRange() {
 		s += fmt.Sprintf("%+v", p.Extensions.SPIRange)
 	}
 
-	if p.Present.Proposal {
+	if p.HasProposal() {
 		s += fmt.Sprintf("%+v", p.Extensions.Proposal)
+		for _, c := range p.Extensions.ProposalCombs {
+			s += fmt.Sprintf("%+v", c)
+		}
 	}
 
-	if p.Present.XPolicy {
+	if p.HasXPolicy() {
 		s += fmt.Sprintf("%+v", p.Extensions.XPolicy)
+		for _, r := range p.Extensions.XPolicyRequests {
+			s += fmt.Sprintf("%+v", r.Request)
+			if r.HasTunnel() {
+				s += fmt.Sprintf("%+v %+v", r.SockAddrSrc, r.SockAddrDst)
+			}
+		}
+	}
+
+	if p.HasSA2() {
+		s += fmt.Sprintf("%+v", p.Extensions.SA2)
+	}
+
+	if p.HasNATTType() {
+		s += fmt.Sprintf("%+v", p.Extensions.NATTType)
+	}
+
+	if p.HasNATTSport() {
+		s += fmt.Sprintf("%+v", p.Extensions.NATTSport)
+	}
+
+	if p.HasNATTDport() {
+		s += fmt.Sprintf("%+v", p.Extensions.NATTDport)
+	}
+
+	if p.HasNATTOA() {
+		s += fmt.Sprintf("%+v %+v", p.Extensions.NATTOA, p.Extensions.SockAddrNATTOA)
+	}
+
+	if p.HasSecCtx() {
+		s += fmt.Sprintf("%+v %q", p.Extensions.SecCtx, p.Extensions.SecCtxBits)
+	}
+
+	if p.HasKMAddress() {
+		s += fmt.Sprintf("%+v %+v %+v", p.Extensions.KMAddress, p.Extensions.SockAddrKMLocal, p.Extensions.SockAddrKMRemote)
 	}
 
 	return s
@@ -62,58 +109,121 @@ func (p *Msg) writeToBuffer(buf *msgBuffer) error {
 	// TODO: Automatically set message length here
 	// TODO: This method might need actual error checking
 
-	buf.writeStruct(p.Msg)
+	if p.HasAddressSrc() && p.HasAddressDst() && p.Extensions.SockAddrSrc.family() != p.Extensions.SockAddrDst.family() {
+		return fmt.Errorf("source (family %d) and destination (family %d) addresses belong to different address families",
+			p.Extensions.SockAddrSrc.family(), p.Extensions.SockAddrDst.family())
+	}
+
+	buf.writeStruct(&p.Msg)
 
 	if p.HasSA() {
-		buf.writeStruct(p.Extensions.SA)
+		buf.writeStruct(&p.Extensions.SA)
+	}
+
+	if p.HasSA2() {
+		buf.writeStruct(&p.Extensions.SA2)
 	}
 
 	if p.HasLifetimeCurrent() {
-		buf.writeStruct(p.Extensions.LifetimeCurrent)
+		buf.writeStruct(&p.Extensions.LifetimeCurrent)
 	}
 
 	if p.HasLifetimeHard() {
-		buf.writeStruct(p.Extensions.LifetimeHard)
+		buf.writeStruct(&p.Extensions.LifetimeHard)
 	}
 
 	if p.HasLifetimeSoft() {
-		buf.writeStruct(p.Extensions.LifetimeSoft)
+		buf.writeStruct(&p.Extensions.LifetimeSoft)
 	}
 
 	if p.HasAddressSrc() {
-		buf.writeStruct(p.Extensions.AddressSrc)
+		buf.writeStruct(&p.Extensions.AddressSrc)
 		buf.writeStruct(p.Extensions.SockAddrSrc)
+		buf.align()
 	}
 
 	if p.HasAddressDst() {
-		buf.writeStruct(p.Extensions.AddressDst)
+		buf.writeStruct(&p.Extensions.AddressDst)
 		buf.writeStruct(p.Extensions.SockAddrDst)
+		buf.align()
+	}
+
+	if p.HasAddressProxy() {
+		buf.writeStruct(&p.Extensions.AddressProxy)
+		buf.writeStruct(p.Extensions.SockAddrProxy)
+		buf.align()
 	}
 
 	if p.HasAuthKey() {
-		buf.writeStruct(p.Extensions.AuthKey)
+		buf.writeStruct(&p.Extensions.AuthKey)
 		if p.Extensions.AuthKey.Len > 1 {
-			buf.writeBytes(p.Extensions.AuthKeyBits)
+			buf.writePadded(p.Extensions.AuthKeyBits)
 		}
 	}
 
 	if p.HasEncryptKey() {
-		buf.writeStruct(p.Extensions.EncryptKey)
+		buf.writeStruct(&p.Extensions.EncryptKey)
 		if p.Extensions.EncryptKey.Len > 1 {
-			buf.writeBytes(p.Extensions.EncryptKeyBits)
+			buf.writePadded(p.Extensions.EncryptKeyBits)
 		}
 	}
 
+	if p.HasAuthAlgorithms() {
+		writeSupported(buf, SADB_EXT_SUPPORTED_AUTH, p.Extensions.AuthAlgorithms)
+	}
+
+	if p.HasEncryptAlgorithms() {
+		writeSupported(buf, SADB_EXT_SUPPORTED_ENCRYPT, p.Extensions.EncryptAlgorithms)
+	}
+
 	if p.HasSPIRange() {
-		buf.writeStruct(p.Extensions.SPIRange)
+		buf.writeStruct(&p.Extensions.SPIRange)
+	}
+
+	if p.HasNATTType() {
+		buf.writeStruct(&p.Extensions.NATTType)
 	}
 
-	if p.Present.Proposal {
-		simplelog.Fatal.Println("Writing of proposal is not implemented")
+	if p.HasNATTSport() {
+		buf.writeStruct(&p.Extensions.NATTSport)
 	}
 
-	if p.Present.XPolicy {
-		simplelog.Fatal.Println("Writing of XPolicy is not implemented")
+	if p.HasNATTDport() {
+		buf.writeStruct(&p.Extensions.NATTDport)
+	}
+
+	if p.HasNATTOA() {
+		buf.writeStruct(&p.Extensions.NATTOA)
+		buf.writeStruct(p.Extensions.SockAddrNATTOA)
+		buf.align()
+	}
+
+	if p.HasSecCtx() {
+		buf.writeStruct(&p.Extensions.SecCtx)
+		buf.writePadded(p.Extensions.SecCtxBits)
+	}
+
+	if p.HasProposal() {
+		buf.writeStruct(&p.Extensions.Proposal)
+		for i := range p.Extensions.ProposalCombs {
+			buf.writeStruct(&p.Extensions.ProposalCombs[i])
+		}
+	}
+
+	if p.HasXPolicy() {
+		buf.writeStruct(&p.Extensions.XPolicy)
+		for i := range p.Extensions.XPolicyRequests {
+			if err := p.Extensions.XPolicyRequests[i].writeToBuffer(buf); err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.HasKMAddress() {
+		buf.writeStruct(&p.Extensions.KMAddress)
+		buf.writeStruct(p.Extensions.SockAddrKMLocal)
+		buf.writeStruct(p.Extensions.SockAddrKMRemote)
+		buf.align()
 	}
 
 	return nil
@@ -126,12 +236,14 @@ func (p *Msg) setMsgLen() {
 
 	p.Msg.Version = PF_KEY_V2
 
-	// TODO: Are we missing sadb_supported here? (and maybe others)
-
 	if p.HasSA() {
 		n += p.Extensions.SA.Len
 	}
 
+	if p.HasSA2() {
+		n += p.Extensions.SA2.Len
+	}
+
 	if p.HasLifetimeCurrent() {
 		n += p.Extensions.LifetimeCurrent.Len
 	}
@@ -152,11 +264,13 @@ func (p *Msg) setMsgLen() {
 		n += p.Extensions.AddressDst.Len
 	}
 
-	// TODO: Move setting the exttype/len to its own method
-	if p.Present.Proposal {
+	if p.HasAddressProxy() {
+		n += p.Extensions.AddressProxy.Len
+	}
+
+	if p.HasProposal() {
 		p.Extensions.Proposal.ExtType = SADB_EXT_PROPOSAL
-		combLen := uint16(len(p.Extensions.ProposalCombs) * SADBCOMB_LEN)
-		p.Extensions.Proposal.Len = SADBPROP_LEN + combLen
+		p.Extensions.Proposal.Len = proposalLen(p.Extensions.ProposalCombs)
 		n += p.Extensions.Proposal.Len
 	}
 
@@ -168,17 +282,48 @@ func (p *Msg) setMsgLen() {
 		n += p.Extensions.EncryptKey.Len
 	}
 
+	if p.HasAuthAlgorithms() {
+		n += supportedLen(p.Extensions.AuthAlgorithms)
+	}
+
+	if p.HasEncryptAlgorithms() {
+		n += supportedLen(p.Extensions.EncryptAlgorithms)
+	}
+
 	if p.HasSPIRange() {
 		n += p.Extensions.SPIRange.Len
 	}
 
-	// TODO: Move setting the exttype/len to its own method
-	if p.Present.XPolicy {
+	if p.HasNATTType() {
+		n += p.Extensions.NATTType.Len
+	}
+
+	if p.HasNATTSport() {
+		n += p.Extensions.NATTSport.Len
+	}
+
+	if p.HasNATTDport() {
+		n += p.Extensions.NATTDport.Len
+	}
+
+	if p.HasNATTOA() {
+		n += p.Extensions.NATTOA.Len
+	}
+
+	if p.HasSecCtx() {
+		n += p.Extensions.SecCtx.Len
+	}
+
+	if p.HasXPolicy() {
 		p.Extensions.XPolicy.ExtType = SADB_X_EXT_POLICY
-		p.Extensions.XPolicy.Len = SADBXPOLICY_LEN
+		p.Extensions.XPolicy.Len = xPolicyLen(p.Extensions.XPolicyRequests)
 		n += p.Extensions.XPolicy.Len
 	}
 
+	if p.HasKMAddress() {
+		n += p.Extensions.KMAddress.Len
+	}
+
 	// Add the size of the base message to the size of all the extensions
 	p.Msg.Len = n + SADBMSG_LEN
 }
@@ -197,6 +342,20 @@ func (p *Msg) HasSA() bool {
 	return p.Present.SA
 }
 
+// SetSA2 sets the value for the SA2 extension on this PFKEYMsg
+func (p *Msg) SetSA2(sa2 SADBXSA2) {
+	p.Extensions.SA2 = sa2
+	p.Extensions.SA2.ExtType = SADB_X_EXT_SA2
+	p.Extensions.SA2.Len = SADBXSA2_LEN
+
+	p.Present.SA2 = true
+}
+
+// HasSA2 returns true if this PFKEYMsg has the SA2 extension present.
+func (p *Msg) HasSA2() bool {
+	return p.Present.SA2
+}
+
 // SetLifetimeCurrent sets the value for the LifetimeCurrent extension on this PFKEYMsg
 func (p *Msg) SetLifetimeCurrent(lt SADBLifetime) {
 	p.Extensions.LifetimeCurrent = lt
@@ -241,18 +400,23 @@ func (p *Msg) HasLifetimeSoft() bool {
 
 // SetAddressSrc sets the value for the AddressSrc extension on this PFKEYMsg
 func (p *Msg) SetAddressSrc(src Node) {
-	// TODO: This needs to change when we support other sockaddr structures (for IPv6 for example)
-	p.Extensions.AddressSrc = SADBAddress{
-		Proto:     0,
-		PrefixLen: 32,
-	}
-	p.Extensions.SockAddrSrc = src.buildSockAddr()
-	p.Extensions.AddressSrc.ExtType = SADB_EXT_ADDRESS_SRC
-	p.Extensions.AddressSrc.Len = SADBADDRESS_LEN + SOCKADDRIN_LEN
+	p.Extensions.AddressSrc, p.Extensions.SockAddrSrc = newAddress(SADB_EXT_ADDRESS_SRC, src.buildSockAddr())
 
 	p.Present.AddressSrc = true
 }
 
+// newAddress builds a sadb_address extension of type extType for sAddr, returning it along with sAddr.
+// The length and prefix length of the extension depend on the address family of sAddr.
+func newAddress(extType uint16, sAddr sockAddr) (SADBAddress, sockAddr) {
+	address := SADBAddress{
+		Len:       SADBADDRESS_LEN + uint16((sAddr.wireSize()+WORD_SIZE-1)/WORD_SIZE),
+		ExtType:   extType,
+		Proto:     0,
+		PrefixLen: sAddr.prefixLen(),
+	}
+	return address, sAddr
+}
+
 // HasAddressSrc returns true if this PFKEYMsg has the AddressSrc extension present.
 func (p *Msg) HasAddressSrc() bool {
 	return p.Present.AddressSrc
@@ -260,14 +424,7 @@ func (p *Msg) HasAddressSrc() bool {
 
 // SetAddressDst sets the value for the AddressDst extension on this PFKEYMsg
 func (p *Msg) SetAddressDst(dst Node) {
-	// TODO: This needs to change when we support other sockaddr structures (for IPv6 for example)
-	p.Extensions.AddressDst = SADBAddress{
-		Proto:     0,
-		PrefixLen: 32,
-	}
-	p.Extensions.SockAddrDst = dst.buildSockAddr()
-	p.Extensions.AddressDst.ExtType = SADB_EXT_ADDRESS_DST
-	p.Extensions.AddressDst.Len = SADBADDRESS_LEN + SOCKADDRIN_LEN
+	p.Extensions.AddressDst, p.Extensions.SockAddrDst = newAddress(SADB_EXT_ADDRESS_DST, dst.buildSockAddr())
 
 	p.Present.AddressDst = true
 }
@@ -277,12 +434,24 @@ func (p *Msg) HasAddressDst() bool {
 	return p.Present.AddressDst
 }
 
+// SetAddressProxy sets the value for the AddressProxy extension on this PFKEYMsg
+func (p *Msg) SetAddressProxy(proxy Node) {
+	p.Extensions.AddressProxy, p.Extensions.SockAddrProxy = newAddress(SADB_EXT_ADDRESS_PROXY, proxy.buildSockAddr())
+
+	p.Present.AddressProxy = true
+}
+
+// HasAddressProxy returns true if this PFKEYMsg has the AddressProxy extension present.
+func (p *Msg) HasAddressProxy() bool {
+	return p.Present.AddressProxy
+}
+
 // SetAuthKey builds the SADB_EXT_KEY_AUTH extension for this PFKEYMsg.
 func (p *Msg) SetAuthKey(key []byte, keySize int) {
 	p.Extensions.AuthKey = SADBKey{
 		Bits:    uint16(keySize),
 		ExtType: SADB_EXT_KEY_AUTH,
-		Len:     SADBKEY_LEN + uint16(len(key)/WORD_SIZE),
+		Len:     SADBKEY_LEN + uint16((len(key)+WORD_SIZE-1)/WORD_SIZE),
 	}
 	p.Extensions.AuthKeyBits = key
 	p.Present.AuthKey = true
@@ -298,7 +467,7 @@ func (p *Msg) SetEncryptKey(key []byte, keySize int) {
 	p.Extensions.EncryptKey = SADBKey{
 		Bits:    uint16(keySize),
 		ExtType: SADB_EXT_KEY_ENCRYPT,
-		Len:     SADBKEY_LEN + uint16(len(key)/WORD_SIZE),
+		Len:     SADBKEY_LEN + uint16((len(key)+WORD_SIZE-1)/WORD_SIZE),
 	}
 	p.Extensions.EncryptKeyBits = key
 	p.Present.EncryptKey = true
@@ -309,6 +478,45 @@ func (p *Msg) HasEncryptKey() bool {
 	return p.Present.EncryptKey
 }
 
+// SetAuthAlgorithms sets the list of algorithms for the SADB_EXT_SUPPORTED_AUTH extension on this PFKEYMsg
+func (p *Msg) SetAuthAlgorithms(algs []SADBAlg) {
+	p.Extensions.AuthAlgorithms = algs
+	p.Present.AuthAlgorithms = true
+}
+
+// HasAuthAlgorithms returns true if this PFKEYMsg has the SADB_EXT_SUPPORTED_AUTH extension present.
+func (p *Msg) HasAuthAlgorithms() bool {
+	return p.Present.AuthAlgorithms
+}
+
+// SetEncryptAlgorithms sets the list of algorithms for the SADB_EXT_SUPPORTED_ENCRYPT extension on this PFKEYMsg
+func (p *Msg) SetEncryptAlgorithms(algs []SADBAlg) {
+	p.Extensions.EncryptAlgorithms = algs
+	p.Present.EncryptAlgorithms = true
+}
+
+// HasEncryptAlgorithms returns true if this PFKEYMsg has the SADB_EXT_SUPPORTED_ENCRYPT extension present.
+func (p *Msg) HasEncryptAlgorithms() bool {
+	return p.Present.EncryptAlgorithms
+}
+
+// supportedLen returns the length (in 64-bit words) of a sadb_supported extension followed by the given algorithms.
+func supportedLen(algs []SADBAlg) uint16 {
+	return SADBSUPPORTED_LEN + uint16(len(algs)*SADBALG_LEN)
+}
+
+// writeSupported writes a sadb_supported extension of type extType followed by the given algorithms.
+func writeSupported(buf *msgBuffer, extType uint16, algs []SADBAlg) {
+	supported := SADBSupported{
+		Len:     supportedLen(algs),
+		ExtType: extType,
+	}
+	buf.writeStruct(&supported)
+	for i := range algs {
+		buf.writeStruct(&algs[i])
+	}
+}
+
 // SetSPIRANGE adds the SADBSPIRange extension to this PFKEYMsg
 func (p *Msg) SetSPIRANGE(min int, max int) {
 
@@ -326,7 +534,135 @@ func (p *Msg) HasSPIRange() bool {
 	return p.Present.SPIRange
 }
 
-// SetProposal sets the value of the Proposal extension on this PFKEYMsg
-func (p *Msg) SetProposal() {
-	// XXX TODO Implement
+// SetNATTType sets the value for the NATTType extension on this PFKEYMsg.
+// encapType should be one of the UDP_ENCAP_* constants.
+func (p *Msg) SetNATTType(encapType uint8) {
+	p.Extensions.NATTType = SADBXNATTType{
+		Len:     SADBXNATTTYPE_LEN,
+		ExtType: SADB_X_EXT_NAT_T_TYPE,
+		Type:    encapType,
+	}
+	p.Present.NATTType = true
+}
+
+// HasNATTType returns true if this PFKEYMsg has the NATTType extension present.
+func (p *Msg) HasNATTType() bool {
+	return p.Present.NATTType
+}
+
+// SetNATTSport sets the value for the NATTSport extension on this PFKEYMsg.
+// port is expected in machine order.
+func (p *Msg) SetNATTSport(port uint16) {
+	p.Extensions.NATTSport = SADBXNATTPort{
+		Len:     SADBXNATTPORT_LEN,
+		ExtType: SADB_X_EXT_NAT_T_SPORT,
+		Port:    networkOrder16(port, nativeEndian),
+	}
+	p.Present.NATTSport = true
+}
+
+// HasNATTSport returns true if this PFKEYMsg has the NATTSport extension present.
+func (p *Msg) HasNATTSport() bool {
+	return p.Present.NATTSport
+}
+
+// SetNATTDport sets the value for the NATTDport extension on this PFKEYMsg.
+// port is expected in machine order.
+func (p *Msg) SetNATTDport(port uint16) {
+	p.Extensions.NATTDport = SADBXNATTPort{
+		Len:     SADBXNATTPORT_LEN,
+		ExtType: SADB_X_EXT_NAT_T_DPORT,
+		Port:    networkOrder16(port, nativeEndian),
+	}
+	p.Present.NATTDport = true
+}
+
+// HasNATTDport returns true if this PFKEYMsg has the NATTDport extension present.
+func (p *Msg) HasNATTDport() bool {
+	return p.Present.NATTDport
+}
+
+// SetNATTOA sets the value for the NATTOA (original address) extension on this PFKEYMsg
+func (p *Msg) SetNATTOA(oa Node) {
+	p.Extensions.NATTOA, p.Extensions.SockAddrNATTOA = newAddress(SADB_X_EXT_NAT_T_OA, oa.buildSockAddr())
+
+	p.Present.NATTOA = true
+}
+
+// HasNATTOA returns true if this PFKEYMsg has the NATTOA extension present.
+func (p *Msg) HasNATTOA() bool {
+	return p.Present.NATTOA
+}
+
+// SetSecCtx sets the value for the SecCtx (security context) extension on this PFKEYMsg.
+// doi and alg should be one of the XFRM_SC_DOI_* and XFRM_SC_ALG_* constants respectively.
+func (p *Msg) SetSecCtx(doi uint8, alg uint8, ctx []byte) {
+	p.Extensions.SecCtx = SADBXSecCtx{
+		Len:     SADBXSECCTX_LEN + uint16((len(ctx)+WORD_SIZE-1)/WORD_SIZE),
+		ExtType: SADB_X_EXT_SEC_CTX,
+		CtxDOI:  doi,
+		CtxAlg:  alg,
+		CtxLen:  uint16(len(ctx)),
+	}
+	p.Extensions.SecCtxBits = ctx
+	p.Present.SecCtx = true
+}
+
+// HasSecCtx returns true if this PFKEYMsg has the SecCtx extension present.
+func (p *Msg) HasSecCtx() bool {
+	return p.Present.SecCtx
+}
+
+// SetXPolicy sets the value for the XPolicy extension on this PFKEYMsg, along with the IPsec requests that follow it.
+func (p *Msg) SetXPolicy(policy SADBXPolicy, requests []IPSecRequest) {
+	p.Extensions.XPolicy = policy
+	p.Extensions.XPolicy.ExtType = SADB_X_EXT_POLICY
+	p.Extensions.XPolicy.Len = xPolicyLen(requests)
+	p.Extensions.XPolicyRequests = requests
+
+	p.Present.XPolicy = true
+}
+
+// HasXPolicy returns true if this PFKEYMsg has the XPolicy extension present.
+func (p *Msg) HasXPolicy() bool {
+	return p.Present.XPolicy
+}
+
+// SetKMAddress sets the value for the KMAddress extension on this PFKEYMsg
+func (p *Msg) SetKMAddress(local Node, remote Node) {
+	p.Extensions.SockAddrKMLocal = local.buildSockAddr()
+	p.Extensions.SockAddrKMRemote = remote.buildSockAddr()
+	p.Extensions.KMAddress = SADBXKMAddress{
+		Len:     SADBXKMADDRESS_LEN + sockAddrPairLen(p.Extensions.SockAddrKMLocal, p.Extensions.SockAddrKMRemote)/WORD_SIZE,
+		ExtType: SADB_X_EXT_KMADDRESS,
+	}
+
+	p.Present.KMAddress = true
+}
+
+// HasKMAddress returns true if this PFKEYMsg has the KMAddress extension present.
+func (p *Msg) HasKMAddress() bool {
+	return p.Present.KMAddress
+}
+
+// SetProposal sets the value of the Proposal extension on this PFKEYMsg, with replay as the
+// replay window size and combs as the list of acceptable algorithm combinations, in order of preference.
+func (p *Msg) SetProposal(replay uint8, combs []SADBComb) {
+	p.Extensions.Proposal = SADBProp{
+		Len:     proposalLen(combs),
+		ExtType: SADB_EXT_PROPOSAL,
+		Replay:  replay,
+	}
+	p.Extensions.ProposalCombs = combs
+	p.Present.Proposal = true
+}
+
+// HasProposal returns true if this PFKEYMsg has the Proposal extension present.
+func (p *Msg) HasProposal() bool {
+	return p.Present.Proposal
+}
+
+// proposalLen returns the length (in 64-bit words) of a sadb_prop extension followed by the given combs.
+func proposalLen(combs []SADBComb) uint16 {
+	return SADBPROP_LEN + uint16(len(combs)*SADBCOMB_LEN)
 }
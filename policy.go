@@ -0,0 +1,153 @@
+package pfkey
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// NewIPSecRequest builds an IPSecRequest for the given protocol (IPPROTO_ESP, IPPROTO_AH or IPPROTO_COMP),
+// mode (one of IPSEC_MODE_*), level (one of IPSEC_LEVEL_*) and reqid.
+// Requests are applied in order, so an IPComp+ESP bundle lists the IPPROTO_COMP request first. IPComp requests
+// usually want IPSEC_LEVEL_USE, since the kernel doesn't compress packets too small to benefit from it.
+func NewIPSecRequest(proto uint16, mode uint8, level uint8, reqid uint32) IPSecRequest {
+	return IPSecRequest{
+		Request: SADBXIPSecRequest{
+			Len:   SADBXIPSECREQUEST_LEN,
+			Proto: proto,
+			Mode:  mode,
+			Level: level,
+			ReqID: reqid,
+		},
+	}
+}
+
+// SetTunnel sets the endpoints of the tunnel for this IPSecRequest.
+// Both endpoints need to belong to the same address family.
+func (r *IPSecRequest) SetTunnel(src Node, dst Node) {
+	r.SockAddrSrc = src.buildSockAddr()
+	r.SockAddrDst = dst.buildSockAddr()
+	r.Request.Len = SADBXIPSECREQUEST_LEN + sockAddrPairLen(r.SockAddrSrc, r.SockAddrDst)
+}
+
+// HasTunnel returns true if this IPSecRequest carries the endpoints of a tunnel.
+func (r *IPSecRequest) HasTunnel() bool {
+	return r.Request.Len > SADBXIPSECREQUEST_LEN
+}
+
+// Tunnel returns the endpoints of the tunnel for this IPSecRequest.
+func (r *IPSecRequest) Tunnel() (Node, Node, error) {
+	src, err := r.SockAddrSrc.BuildNode()
+	if err != nil {
+		return Node{}, Node{}, err
+	}
+
+	dst, err := r.SockAddrDst.BuildNode()
+	if err != nil {
+		return Node{}, Node{}, err
+	}
+
+	return src, dst, nil
+}
+
+// validate checks that this IPSecRequest can be understood by the kernel.
+func (r *IPSecRequest) validate() error {
+	if !r.HasTunnel() {
+		if r.Request.Len != SADBXIPSECREQUEST_LEN {
+			return fmt.Errorf("invalid sadb_x_ipsecrequest length: %d", r.Request.Len)
+		}
+	} else {
+		if r.SockAddrSrc == nil || r.SockAddrDst == nil ||
+			r.Request.Len != SADBXIPSECREQUEST_LEN+sockAddrPairLen(r.SockAddrSrc, r.SockAddrDst) {
+			return fmt.Errorf("invalid sadb_x_ipsecrequest length: %d", r.Request.Len)
+		}
+
+		if r.SockAddrSrc.family() != r.SockAddrDst.family() {
+			return errors.New("tunnel endpoints of IPsec request belong to different address families")
+		}
+	}
+
+	if r.Request.Mode == IPSEC_MODE_TUNNEL && !r.HasTunnel() {
+		return errors.New("tunnel mode IPsec request is missing its endpoints")
+	}
+
+	return nil
+}
+
+func (r *IPSecRequest) writeToBuffer(buf *msgBuffer) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	// Only check the protocol of requests we send, so we can still decode policies for protocols we don't know about
+	switch r.Request.Proto {
+	case unix.IPPROTO_ESP, unix.IPPROTO_AH, unix.IPPROTO_COMP:
+	default:
+		return fmt.Errorf("unsupported IPsec request protocol %d", r.Request.Proto)
+	}
+
+	err := buf.writeStruct(&r.Request)
+	if err != nil {
+		return err
+	}
+
+	if r.HasTunnel() {
+		err = buf.writeStruct(r.SockAddrSrc)
+		if err != nil {
+			return err
+		}
+		err = buf.writeStruct(r.SockAddrDst)
+		buf.align()
+	}
+	return err
+}
+
+// xPolicyLen returns the length (in 64-bit words) of a sadb_x_policy extension followed by the given requests.
+func xPolicyLen(requests []IPSecRequest) uint16 {
+	n := uint16(SADBXPOLICY_LEN)
+	for _, r := range requests {
+		n += r.Request.Len / WORD_SIZE
+	}
+	return n
+}
+
+// parseIPSecRequests parses all the sadb_x_ipsecrequest structures following the sadb_x_policy header in ext.
+func parseIPSecRequests(ext []byte, order binary.ByteOrder) ([]IPSecRequest, error) {
+	var requests []IPSecRequest
+
+	b := ext[SADBXPOLICY_LEN*WORD_SIZE:]
+	for len(b) > 0 {
+		var r IPSecRequest
+		err := r.Request.unmarshal(b, order)
+		if err != nil {
+			return requests, err
+		}
+
+		if int(r.Request.Len) > len(b) {
+			return requests, fmt.Errorf("sadb_x_ipsecrequest of length %d overflows its sadb_x_policy extension", r.Request.Len)
+		}
+
+		if r.Request.Len > SADBXIPSECREQUEST_LEN {
+			addrs := b[SADBXIPSECREQUEST_LEN:r.Request.Len]
+			r.SockAddrSrc, err = parseSockAddr(addrs, order)
+			if err != nil {
+				return requests, err
+			}
+			r.SockAddrDst, err = parseSockAddr(addrs[r.SockAddrSrc.wireSize():], order)
+			if err != nil {
+				return requests, err
+			}
+		}
+
+		if err = r.validate(); err != nil {
+			return requests, err
+		}
+
+		requests = append(requests, r)
+		b = b[r.Request.Len:]
+	}
+
+	return requests, nil
+}
@@ -0,0 +1,146 @@
+package pfkey
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXPolicyBundleRoundTrip(t *testing.T) {
+	esp := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 1)
+	esp.SetTunnel(Node{Addr: net.IPv4(192, 168, 0, 1)}, Node{Addr: net.IPv4(198, 51, 100, 1)})
+	ah := NewIPSecRequest(unix.IPPROTO_AH, IPSEC_MODE_TRANSPORT, IPSEC_LEVEL_UNIQUE, 2)
+
+	msg := Msg{Msg: SADBMsg{Type: SADB_X_SPDADD, SAType: SADB_SATYPE_UNSPEC}}
+	msg.SetAddressSrc(Node{Addr: net.IPv4(10, 1, 0, 0)})
+	msg.SetAddressDst(Node{Addr: net.IPv4(10, 2, 0, 0)})
+	msg.SetXPolicy(SADBXPolicy{Type: IPSEC_POLICY_IPSEC, Dir: IPSEC_DIR_OUTBOUND, Priority: 2147483648}, []IPSecRequest{esp, ah})
+
+	// 2 words of sadb_x_policy, 6 for the tunnel request and 2 for the transport one
+	if msg.Extensions.XPolicy.Len != 10 {
+		t.Errorf("Expected policy length 10 but got %d instead", msg.Extensions.XPolicy.Len)
+	}
+
+	received := roundTripMsg(t, msg)
+
+	if !received.HasXPolicy() {
+		t.Fatal("Expected XPolicy extension to be present")
+	}
+
+	if received.Extensions.XPolicy != msg.Extensions.XPolicy {
+		t.Errorf("Expected policy %+v but got %+v instead", msg.Extensions.XPolicy, received.Extensions.XPolicy)
+	}
+
+	if !reflect.DeepEqual(received.Extensions.XPolicyRequests, msg.Extensions.XPolicyRequests) {
+		t.Errorf("Expected requests %+v but got %+v instead", msg.Extensions.XPolicyRequests, received.Extensions.XPolicyRequests)
+	}
+}
+
+func TestReceiveXPolicyWithRequest(t *testing.T) {
+	received := []byte{
+		// sadb_msg: SADB_X_SPDDUMP, len 10
+		2, 18, 0, 0, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		// sadb_x_policy: len 8, IPSEC_POLICY_IPSEC, IPSEC_DIR_INBOUND, id 9, priority 0
+		8, 0, 18, 0, 2, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0,
+		// sadb_x_ipsecrequest: len 48, IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, reqid 3
+		48, 0, 50, 0, 2, 2, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0,
+		2, 0, 0, 0, 10, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+		2, 0, 0, 0, 10, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	req := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 3)
+	req.SetTunnel(Node{Addr: net.IPv4(10, 0, 0, 1)}, Node{Addr: net.IPv4(10, 0, 0, 2)})
+
+	expected := Msg{
+		Msg: SADBMsg{Version: PF_KEY_V2, Type: SADB_X_SPDDUMP, Len: 10},
+		Present: sadbExtensionsChecklist{
+			XPolicy: true,
+		},
+		Extensions: sadbExtensions{
+			XPolicy:         SADBXPolicy{Len: 8, ExtType: SADB_X_EXT_POLICY, Type: IPSEC_POLICY_IPSEC, Dir: IPSEC_DIR_INBOUND, ID: 9},
+			XPolicyRequests: []IPSecRequest{req},
+		},
+	}
+
+	ReceivedAndExpectMessage(t, "xpolicy_tunnel_request", received, expected)
+}
+
+func TestReceiveMalformedXPolicy(t *testing.T) {
+	received := []byte{
+		2, 18, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		// sadb_x_policy claims a single word of requests
+		3, 0, 18, 0, 2, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0,
+		// but the request claims to be 48 bytes long
+		48, 0, 50, 0, 2, 2, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	server, client := net.Pipe()
+	go func() {
+		server.Write(received)
+		server.Close()
+	}()
+	p := PFKEY{socket: client}
+
+	if _, err := p.ReadMsg(); err == nil {
+		t.Error("Expected an error when reading a malformed policy")
+	}
+}
+
+func TestWriteTunnelRequestWithoutEndpoints(t *testing.T) {
+	msg := Msg{Msg: SADBMsg{Type: SADB_X_SPDADD}}
+	msg.SetXPolicy(SADBXPolicy{Type: IPSEC_POLICY_IPSEC, Dir: IPSEC_DIR_OUTBOUND}, []IPSecRequest{
+		NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TUNNEL, IPSEC_LEVEL_REQUIRE, 0),
+	})
+
+	if err := msg.writeToBuffer(new(msgBuffer)); err == nil {
+		t.Error("Expected an error when writing a tunnel mode request without endpoints")
+	}
+}
+
+func TestXPolicyIPCompBundle(t *testing.T) {
+	comp := NewIPSecRequest(unix.IPPROTO_COMP, IPSEC_MODE_TRANSPORT, IPSEC_LEVEL_USE, 1)
+	esp := NewIPSecRequest(unix.IPPROTO_ESP, IPSEC_MODE_TRANSPORT, IPSEC_LEVEL_REQUIRE, 1)
+
+	msg := Msg{Msg: SADBMsg{Type: SADB_X_SPDADD, SAType: SADB_SATYPE_UNSPEC}}
+	msg.SetAddressSrc(Node{Addr: net.IPv4(10, 1, 0, 1)})
+	msg.SetAddressDst(Node{Addr: net.IPv4(10, 2, 0, 1)})
+	msg.SetXPolicy(SADBXPolicy{Type: IPSEC_POLICY_IPSEC, Dir: IPSEC_DIR_OUTBOUND}, []IPSecRequest{comp, esp})
+
+	received := roundTripMsg(t, msg)
+
+	if !reflect.DeepEqual(received.Extensions.XPolicyRequests, msg.Extensions.XPolicyRequests) {
+		t.Errorf("Expected requests %+v but got %+v instead", msg.Extensions.XPolicyRequests, received.Extensions.XPolicyRequests)
+	}
+
+	bad := NewIPSecRequest(unix.IPPROTO_UDP, IPSEC_MODE_TRANSPORT, IPSEC_LEVEL_REQUIRE, 1)
+	msg.SetXPolicy(SADBXPolicy{Type: IPSEC_POLICY_IPSEC, Dir: IPSEC_DIR_OUTBOUND}, []IPSecRequest{bad})
+	if err := msg.writeToBuffer(new(msgBuffer)); err == nil {
+		t.Error("Expected an IPsec request for an unsupported protocol to be rejected")
+	}
+}
+
+func TestReceiveXPolicyUnknownProto(t *testing.T) {
+	received := []byte{
+		// sadb_msg: SADB_X_SPDDUMP, len 6
+		2, 18, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		// sadb_x_policy: len 4, IPSEC_POLICY_IPSEC, IPSEC_DIR_INBOUND, id 9, priority 0
+		4, 0, 18, 0, 2, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0,
+		// sadb_x_ipsecrequest: len 16, protocol 99, IPSEC_MODE_TRANSPORT, IPSEC_LEVEL_REQUIRE, reqid 3
+		16, 0, 99, 0, 1, 2, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	msg, err := ParseMsg(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msg.Extensions.XPolicyRequests) != 1 || msg.Extensions.XPolicyRequests[0].Request.Proto != 99 {
+		t.Errorf("Unexpected requests %+v", msg.Extensions.XPolicyRequests)
+	}
+
+	if err = msg.writeToBuffer(new(msgBuffer)); err == nil {
+		t.Error("Expected a request for an unknown protocol to be rejected when writing")
+	}
+}
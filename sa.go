@@ -0,0 +1,337 @@
+package pfkey
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+)
+
+// SAConfig describes a security association to be installed by a SADB_ADD or SADB_UPDATE message.
+type SAConfig struct {
+	// Update builds a SADB_UPDATE message instead of a SADB_ADD one
+	Update bool
+	// SAType is one of SADB_SATYPE_*, SADB_SATYPE_ESP is used if left unspecified.
+	// SADB_SATYPE_AH SAs only take an authentication algorithm and key, SADB_SATYPE_ESP ones need at least one
+	// of an encryption or authentication algorithm
+	SAType uint8
+	Seq    uint32
+	// SPI is in machine order. IPComp SAs hold their 16-bit CPI here
+	SPI uint32
+	// Src and Dst need to belong to the same address family. Their ports are in machine order
+	Src netip.AddrPort
+	Dst netip.AddrPort
+	// EncryptAlg is one of SADB_EALG_* or SADB_X_EALG_*, EncryptKey has to be empty for SADB_EALG_NONE
+	EncryptAlg uint8
+	EncryptKey []byte
+	// Salt is appended to EncryptKey for the algorithms that need one (AES-GCM, AES-CCM, NULL_AES_GMAC and AES-CTR).
+	// It can be left empty if EncryptKey already includes it
+	Salt []byte
+	// AuthAlg is one of SADB_AALG_* or SADB_X_AALG_*, no authentication key is sent for SADB_AALG_NONE
+	AuthAlg uint8
+	AuthKey []byte
+	// CompressAlg is one of SADB_X_CALG_*, only SADB_X_SATYPE_IPCOMP SAs take one
+	CompressAlg uint8
+	// ReplayWindow is the size in packets of the anti-replay window, 0 disables anti-replay
+	ReplayWindow uint8
+	// Flags is a combination of SADB_SAFLAGS_*
+	Flags uint32
+	// State is one of SADB_SASTATE_*, SADB_SASTATE_MATURE is used if left as SADB_SASTATE_LARVAL
+	// since the kernel won't accept larval SAs in SADB_ADD or SADB_UPDATE messages
+	State uint8
+	// SoftLifetime and HardLifetime are only sent when they're not zero
+	SoftLifetime Lifetime
+	HardLifetime Lifetime
+	// Options add optional extensions (such as SADB_X_EXT_SA2) to the message
+	Options []SAOption
+}
+
+// BuildSA builds a SADB_ADD (or SADB_UPDATE) message to create the security association described by cfg.
+func BuildSA(cfg SAConfig) (*Msg, error) {
+	if cfg.Src.Addr().Unmap().Is6() != cfg.Dst.Addr().Unmap().Is6() {
+		return nil, fmt.Errorf("source %s and destination %s belong to different address families", cfg.Src.Addr(), cfg.Dst.Addr())
+	}
+
+	satype := cfg.SAType
+	if satype == SADB_SATYPE_UNSPEC {
+		satype = SADB_SATYPE_ESP
+	}
+	if err := checkSAType(satype); err != nil {
+		return nil, err
+	}
+
+	switch satype {
+	case SADB_SATYPE_AH:
+		if cfg.EncryptAlg != SADB_EALG_NONE || len(cfg.EncryptKey) != 0 {
+			return nil, errors.New("AH SAs can't have an encryption algorithm or key")
+		}
+		if cfg.AuthAlg == SADB_AALG_NONE {
+			return nil, errors.New("AH SAs need an authentication algorithm")
+		}
+	case SADB_X_SATYPE_IPCOMP:
+		if err := checkIPComp(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if satype != SADB_X_SATYPE_IPCOMP && cfg.CompressAlg != SADB_X_CALG_NONE {
+		return nil, errors.New("only IPComp SAs can have a compression algorithm")
+	}
+
+	if cfg.AuthAlg != SADB_AALG_NONE {
+		if err := checkKey(cfg.AuthAlg, false, cfg.AuthKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Flags&^saFlagsMask != 0 {
+		return nil, fmt.Errorf("unknown SA flags %#x", cfg.Flags&^saFlagsMask)
+	}
+
+	encryptKey, err := encryptionKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Msg{}
+
+	p.Msg = SADBMsg{
+		Type:   SADB_ADD,
+		SAType: satype,
+		Seq:    cfg.Seq,
+		PID:    uint32(os.Getpid()),
+	}
+	if cfg.Update {
+		p.Msg.Type = SADB_UPDATE
+	}
+
+	state := cfg.State
+	if state == SADB_SASTATE_LARVAL {
+		state = SADB_SASTATE_MATURE
+	}
+
+	// IPComp SAs carry their compression algorithm in place of the encryption one
+	encrypt := cfg.EncryptAlg
+	if satype == SADB_X_SATYPE_IPCOMP {
+		encrypt = cfg.CompressAlg
+	}
+
+	p.SetSA(SADBSA{
+		SPI:     networkOrder32(cfg.SPI, nativeEndian),
+		Replay:  cfg.ReplayWindow,
+		State:   state,
+		Auth:    cfg.AuthAlg,
+		Encrypt: encrypt,
+		Flags:   cfg.Flags,
+	})
+
+	if cfg.SoftLifetime != (Lifetime{}) {
+		p.SetSoftLifetimeDuration(cfg.SoftLifetime)
+	}
+
+	if cfg.HardLifetime != (Lifetime{}) {
+		p.SetHardLifetimeDuration(cfg.HardLifetime)
+	}
+
+	p.SetAddressSrcAddrPort(cfg.Src)
+	p.SetAddressDstAddrPort(cfg.Dst)
+
+	if cfg.AuthAlg != SADB_AALG_NONE {
+		p.SetAuthKey(cfg.AuthKey, len(cfg.AuthKey)*8)
+	}
+
+	if cfg.EncryptAlg != SADB_EALG_NONE {
+		p.SetEncryptKey(encryptKey, len(encryptKey)*8)
+	}
+
+	if err := p.applyOptions(cfg.Options); err != nil {
+		return p, err
+	}
+
+	// Everything above was validated for satype, so it can't be changed through WithSAType
+	if p.Msg.SAType != satype {
+		return p, errors.New("the SA type of an SAConfig can only be set through its SAType field")
+	}
+
+	if err := checkAlgorithms(p); err != nil {
+		return p, err
+	}
+
+	p.setMsgLen()
+
+	return p, nil
+}
+
+// checkAlgorithms returns an error if the algorithms of the SA built in p, once its options are applied, can't
+// be used together. AEAD algorithms already provide integrity protection, so they can't be combined with an
+// authentication algorithm.
+func checkAlgorithms(p *Msg) error {
+	sa := p.Extensions.SA
+	if p.Msg.SAType == SADB_SATYPE_ESP && sa.Encrypt == SADB_EALG_NONE && sa.Auth == SADB_AALG_NONE {
+		return errors.New("ESP SAs need an encryption or authentication algorithm")
+	}
+	if p.Msg.SAType == SADB_X_SATYPE_IPCOMP && (sa.Auth != SADB_AALG_NONE || p.HasAuthKey() || p.HasEncryptKey()) {
+		return errors.New("IPComp SAs can't have encryption or authentication algorithms or keys")
+	}
+	if p.Msg.SAType == SADB_SATYPE_ESP && sa.Auth != SADB_AALG_NONE {
+		if a, ok := lookupAlgorithmID(sa.Encrypt, true); ok && a.AEAD {
+			return fmt.Errorf("%s is an AEAD algorithm and can't be combined with an authentication algorithm", a.Name)
+		}
+	}
+	return nil
+}
+
+// checkIPComp returns an error if cfg can't describe an IPComp SA: those are identified by a 16-bit CPI
+// and only take a compression algorithm, without any keys.
+func checkIPComp(cfg SAConfig) error {
+	if cfg.SPI > cpiRangeMax {
+		return fmt.Errorf("CPI %#x doesn't fit in 16 bits", cfg.SPI)
+	}
+
+	if cfg.EncryptAlg != SADB_EALG_NONE || cfg.AuthAlg != SADB_AALG_NONE || len(cfg.EncryptKey) != 0 || len(cfg.AuthKey) != 0 {
+		return errors.New("IPComp SAs can't have encryption or authentication algorithms or keys")
+	}
+
+	switch cfg.CompressAlg {
+	case SADB_X_CALG_DEFLATE, SADB_X_CALG_LZS, SADB_X_CALG_LZJH:
+		return nil
+	}
+	return fmt.Errorf("unsupported compression algorithm %d", cfg.CompressAlg)
+}
+
+// encryptionKey returns the encryption key to send for cfg, with its salt appended, after checking it's valid for its algorithm.
+func encryptionKey(cfg SAConfig) ([]byte, error) {
+	if cfg.EncryptAlg == SADB_EALG_NONE {
+		if len(cfg.EncryptKey) != 0 {
+			return nil, errors.New("encryption key given without an encryption algorithm")
+		}
+		if len(cfg.Salt) != 0 {
+			return nil, errors.New("salt given without an encryption algorithm")
+		}
+		return nil, nil
+	}
+
+	key := cfg.EncryptKey
+	if len(cfg.Salt) != 0 {
+		key = make([]byte, 0, len(cfg.EncryptKey)+len(cfg.Salt))
+		key = append(append(key, cfg.EncryptKey...), cfg.Salt...)
+	}
+
+	a, ok := lookupAlgorithmID(cfg.EncryptAlg, true)
+	if !ok {
+		// Algorithms we don't know about are left for the kernel to validate
+		return key, nil
+	}
+
+	if len(cfg.Salt) != 0 && len(cfg.Salt) != a.SaltLen {
+		return nil, fmt.Errorf("invalid salt size of %d bytes for %s, expected %d", len(cfg.Salt), a.Name, a.SaltLen)
+	}
+
+	if err := checkKey(cfg.EncryptAlg, true, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SAFlags holds the SADB_SAFLAGS_* flags of an SA as named booleans.
+type SAFlags struct {
+	PFS bool
+	// NoECN disables ECN propagation when decapsulating tunnel mode packets
+	NoECN bool
+	// DecapDSCP copies the DSCP field from the outer header when decapsulating tunnel mode packets
+	DecapDSCP bool
+	// NoPMTUDisc stops setting the DF bit on the outer header of tunnel mode packets
+	NoPMTUDisc bool
+}
+
+// ParseSAFlags returns the SAFlags set in flags (as found in SADBSA), ignoring the ones we don't know about.
+func ParseSAFlags(flags uint32) SAFlags {
+	return SAFlags{
+		PFS:        flags&SADB_SAFLAGS_PFS != 0,
+		NoECN:      flags&SADB_SAFLAGS_NOECN != 0,
+		DecapDSCP:  flags&SADB_SAFLAGS_DECAP_DSCP != 0,
+		NoPMTUDisc: flags&SADB_SAFLAGS_NOPMTUDISC != 0,
+	}
+}
+
+// Bits returns these SAFlags as a combination of SADB_SAFLAGS_*, as stored in SADBSA.
+func (f SAFlags) Bits() uint32 {
+	var flags uint32
+	if f.PFS {
+		flags |= SADB_SAFLAGS_PFS
+	}
+	if f.NoECN {
+		flags |= SADB_SAFLAGS_NOECN
+	}
+	if f.DecapDSCP {
+		flags |= SADB_SAFLAGS_DECAP_DSCP
+	}
+	if f.NoPMTUDisc {
+		flags |= SADB_SAFLAGS_NOPMTUDISC
+	}
+	return flags
+}
+
+// SAInfo describes a security association received from the kernel, such as the ones in the reply to a SADB_DUMP.
+type SAInfo struct {
+	SAType uint8
+	// SPI is in machine order
+	SPI uint32
+	// Src and Dst ports are in machine order
+	Src        netip.AddrPort
+	Dst        netip.AddrPort
+	State      uint8
+	EncryptAlg uint8
+	AuthAlg    uint8
+	// AEAD is true if EncryptAlg is an AEAD algorithm, which provides integrity protection by itself
+	AEAD bool
+	// ICVLen is the size in bytes of the integrity check value of the AEAD or authentication algorithm, 0 if unknown
+	ICVLen       int
+	ReplayWindow uint8
+	Flags        SAFlags
+	// SoftLifetime, HardLifetime and Usage are left as zero if the message doesn't carry them
+	SoftLifetime Lifetime
+	HardLifetime Lifetime
+	Usage        Usage
+}
+
+// DecodeSA decodes the SA and address extensions of this PFKEYMsg into a SAInfo.
+func (p *Msg) DecodeSA() (SAInfo, error) {
+	var info SAInfo
+
+	if !p.HasSA() || !p.HasAddressSrc() || !p.HasAddressDst() {
+		return info, errors.New("message is missing the SA or address extensions")
+	}
+
+	sa := p.Extensions.SA
+	info = SAInfo{
+		SAType:       p.Msg.SAType,
+		SPI:          sa.GetSPI(),
+		Src:          p.Extensions.SockAddrSrc.addrPort(),
+		Dst:          p.Extensions.SockAddrDst.addrPort(),
+		State:        sa.State,
+		EncryptAlg:   sa.Encrypt,
+		AuthAlg:      sa.Auth,
+		ReplayWindow: sa.Replay,
+		Flags:        ParseSAFlags(sa.Flags),
+	}
+
+	if a, ok := lookupAlgorithmID(sa.Encrypt, true); ok && a.AEAD && info.SAType != SADB_X_SATYPE_IPCOMP {
+		info.AEAD = true
+		info.ICVLen = a.ICVLen
+	} else if a, ok := lookupAlgorithmID(sa.Auth, false); ok {
+		info.ICVLen = a.ICVLen
+	}
+
+	if p.HasLifetimeSoft() {
+		info.SoftLifetime = newLifetime(p.Extensions.LifetimeSoft)
+	}
+	if p.HasLifetimeHard() {
+		info.HardLifetime = newLifetime(p.Extensions.LifetimeHard)
+	}
+	if p.HasLifetimeCurrent() {
+		info.Usage = newUsage(p.Extensions.LifetimeCurrent)
+	}
+
+	return info, nil
+}
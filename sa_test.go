@@ -0,0 +1,400 @@
+package pfkey
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestBuildSA(t *testing.T) {
+	cfg := SAConfig{
+		Seq:          7,
+		SPI:          0xc0ffee01,
+		Src:          netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst:          netip.MustParseAddrPort("10.0.0.2:0"),
+		EncryptAlg:   SADB_X_EALG_AESCBC,
+		EncryptKey:   bytes.Repeat([]byte{1}, 16),
+		AuthAlg:      SADB_X_AALG_SHA2_256HMAC,
+		AuthKey:      bytes.Repeat([]byte{2}, 32),
+		ReplayWindow: 32,
+		SoftLifetime: Lifetime{Bytes: 1 << 30, AddTime: 50 * time.Minute},
+		HardLifetime: Lifetime{Bytes: 1 << 31, AddTime: time.Hour},
+	}
+
+	msg, err := BuildSA(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Msg.Type != SADB_ADD || msg.Msg.SAType != SADB_SATYPE_ESP || msg.Msg.Seq != 7 {
+		t.Errorf("Unexpected message header %+v", msg.Msg)
+	}
+
+	expectedSA := SADBSA{
+		Len:     SADBSA_LEN,
+		ExtType: SADB_EXT_SA,
+		SPI:     networkOrder32(0xc0ffee01, nativeEndian),
+		Replay:  32,
+		State:   SADB_SASTATE_MATURE,
+		Auth:    SADB_X_AALG_SHA2_256HMAC,
+		Encrypt: SADB_X_EALG_AESCBC,
+	}
+	if msg.Extensions.SA != expectedSA {
+		t.Errorf("Expected SA %+v but got %+v instead", expectedSA, msg.Extensions.SA)
+	}
+	if msg.Extensions.SA.GetSPI() != cfg.SPI {
+		t.Errorf("Expected SPI %#x but got %#x instead", cfg.SPI, msg.Extensions.SA.GetSPI())
+	}
+
+	if !msg.HasAuthKey() || msg.Extensions.AuthKey.Bits != 256 {
+		t.Errorf("Unexpected auth key %+v", msg.Extensions.AuthKey)
+	}
+	if !msg.HasEncryptKey() || msg.Extensions.EncryptKey.Bits != 128 {
+		t.Errorf("Unexpected encryption key %+v", msg.Extensions.EncryptKey)
+	}
+
+	if msg.Extensions.LifetimeSoft.Addtime != 3000 || msg.Extensions.LifetimeHard.Bytes != 1<<31 {
+		t.Errorf("Unexpected lifetimes %+v and %+v", msg.Extensions.LifetimeSoft, msg.Extensions.LifetimeHard)
+	}
+
+	// sadb_msg + sa + 2 lifetimes + 2 addresses + auth key + encryption key
+	if expected := uint16(2 + 2 + 4 + 4 + 3 + 3 + 5 + 3); msg.Msg.Len != expected {
+		t.Errorf("Expected message length %d but got %d instead", expected, msg.Msg.Len)
+	}
+
+	buf := new(msgBuffer)
+	if err = msg.writeToBuffer(buf); err != nil {
+		t.Fatal(err)
+	}
+	if int(msg.Msg.Len)*WORD_SIZE != len(buf.buf) {
+		t.Errorf("Message length %d doesn't match the %d bytes written", msg.Msg.Len, len(buf.buf))
+	}
+	if spi := buf.buf[SADBMSG_LEN*WORD_SIZE+4:][:4]; !bytes.Equal(spi, []byte{0xc0, 0xff, 0xee, 0x01}) {
+		t.Errorf("Expected the SPI in network order but got %x", spi)
+	}
+}
+
+func TestBuildSADefaults(t *testing.T) {
+	msg, err := BuildSA(SAConfig{
+		Update:     true,
+		Src:        netip.MustParseAddrPort("[2001:db8::1]:0"),
+		Dst:        netip.MustParseAddrPort("[2001:db8::2]:0"),
+		EncryptAlg: SADB_EALG_NULL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Msg.Type != SADB_UPDATE || msg.Msg.SAType != SADB_SATYPE_ESP {
+		t.Errorf("Unexpected message header %+v", msg.Msg)
+	}
+	if msg.Extensions.SA.State != SADB_SASTATE_MATURE {
+		t.Errorf("Expected a mature SA but got state %d", msg.Extensions.SA.State)
+	}
+	if msg.HasLifetimeSoft() || msg.HasLifetimeHard() || msg.HasAuthKey() {
+		t.Errorf("Expected no lifetimes nor auth key in %+v", msg)
+	}
+
+	_, err = BuildSA(SAConfig{
+		Src: netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst: netip.MustParseAddrPort("[2001:db8::2]:0"),
+	})
+	if err == nil {
+		t.Error("Expected an error when building an SA with mixed address families")
+	}
+
+	noAlgs := SAConfig{
+		Src: netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst: netip.MustParseAddrPort("10.0.0.2:0"),
+	}
+	if _, err = BuildSA(noAlgs); err == nil {
+		t.Error("Expected an ESP SA without encryption nor authentication to be rejected")
+	}
+
+	// Authentication given through an option is enough
+	withAuth := noAlgs
+	withAuth.Options = []SAOption{WithAuth(SADB_X_AALG_SHA2_256HMAC, make([]byte, 32))}
+	if _, err = BuildSA(withAuth); err != nil {
+		t.Errorf("Unexpected error building an authentication only ESP SA: %v", err)
+	}
+
+	keyOnly := noAlgs
+	keyOnly.AuthAlg = SADB_X_AALG_SHA2_256HMAC
+	keyOnly.AuthKey = make([]byte, 32)
+	keyOnly.EncryptKey = make([]byte, 16)
+	if _, err = BuildSA(keyOnly); err == nil {
+		t.Error("Expected an encryption key without an encryption algorithm to be rejected")
+	}
+}
+
+func TestBuildSADBADDWithAuth(t *testing.T) {
+	src := Node{Addr: net.ParseIP("10.0.0.1")}
+	dst := Node{Addr: net.ParseIP("10.0.0.2")}
+	authKey := bytes.Repeat([]byte{2}, 20)
+
+	msg, err := BuildSADBADD(1, 1337, src, dst, make([]byte, 32), WithAuth(SADB_AALG_SHA1HMAC, authKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Extensions.SA.Auth != SADB_AALG_SHA1HMAC {
+		t.Errorf("Expected auth algorithm %d but got %d instead", SADB_AALG_SHA1HMAC, msg.Extensions.SA.Auth)
+	}
+	// 160 bit keys need to be padded up to 3 words
+	if !msg.HasAuthKey() || msg.Extensions.AuthKey.Len != SADBKEY_LEN+3 || msg.Extensions.AuthKey.Bits != 160 {
+		t.Errorf("Unexpected auth key %+v", msg.Extensions.AuthKey)
+	}
+
+	buf := new(msgBuffer)
+	if err = msg.writeToBuffer(buf); err != nil {
+		t.Fatal(err)
+	}
+	if int(msg.Msg.Len)*WORD_SIZE != len(buf.buf) {
+		t.Errorf("Message length %d doesn't match the %d bytes written", msg.Msg.Len, len(buf.buf))
+	}
+
+	if _, err = BuildSADBUPDATE(1, 1337, src, dst, make([]byte, 32), WithAuth(SADB_AALG_SHA1HMAC, authKey[:16])); err == nil {
+		t.Error("Expected a 128 bit HMAC-SHA1 key to be rejected")
+	}
+}
+
+func TestBuildSAAH(t *testing.T) {
+	cfg := SAConfig{
+		SAType:  SADB_SATYPE_AH,
+		SPI:     0x1000,
+		Src:     netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst:     netip.MustParseAddrPort("10.0.0.2:0"),
+		AuthAlg: SADB_X_AALG_SHA2_256HMAC,
+		AuthKey: bytes.Repeat([]byte{2}, 32),
+	}
+
+	msg, err := BuildSA(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Msg.SAType != SADB_SATYPE_AH || msg.HasEncryptKey() || !msg.HasAuthKey() {
+		t.Errorf("Unexpected AH message %+v", msg)
+	}
+	if msg.Extensions.SA.Encrypt != SADB_EALG_NONE || msg.Extensions.SA.Auth != SADB_X_AALG_SHA2_256HMAC {
+		t.Errorf("Unexpected AH SA %+v", msg.Extensions.SA)
+	}
+
+	withEncryption := cfg
+	withEncryption.EncryptAlg = SADB_X_EALG_AESCBC
+	withEncryption.EncryptKey = make([]byte, 16)
+	if _, err = BuildSA(withEncryption); err == nil {
+		t.Error("Expected an AH SA with an encryption key to be rejected")
+	}
+
+	withoutAuth := cfg
+	withoutAuth.AuthAlg = SADB_AALG_NONE
+	if _, err = BuildSA(withoutAuth); err == nil {
+		t.Error("Expected an AH SA without authentication to be rejected")
+	}
+
+	src := Node{Addr: net.ParseIP("10.0.0.1")}
+	dst := Node{Addr: net.ParseIP("10.0.0.2")}
+
+	getspi, err := BuildSADBGETSPI(1, src, dst, WithSAType(SADB_SATYPE_AH))
+	if err != nil || getspi.Msg.SAType != SADB_SATYPE_AH {
+		t.Errorf("Unexpected AH SADB_GETSPI message %+v: %v", getspi.Msg, err)
+	}
+
+	del, err := BuildSADBDELETE(0x1000, src, dst, WithSAType(SADB_SATYPE_AH))
+	if err != nil || del.Msg.SAType != SADB_SATYPE_AH {
+		t.Errorf("Unexpected AH SADB_DELETE message %+v: %v", del, err)
+	}
+
+	if _, err = BuildSADBDELETE(0x1000, src, dst, WithSAType(SADB_SATYPE_RSVP)); err == nil {
+		t.Error("Expected an unsupported SA type to be rejected")
+	}
+
+	if dump, err := BuildSADBDUMPMsg(WithSAType(SADB_SATYPE_AH)); err != nil || dump.Msg.SAType != SADB_SATYPE_AH || dump.Msg.Type != SADB_DUMP {
+		t.Errorf("Unexpected AH SADB_DUMP message %+v: %v", dump.Msg, err)
+	}
+
+	if dump, err := BuildSADBDUMPMsg(WithSAType(SADB_SATYPE_UNSPEC)); err != nil || dump.Msg.SAType != SADB_SATYPE_UNSPEC {
+		t.Errorf("Unexpected SADB_DUMP message for every SA type %+v: %v", dump.Msg, err)
+	}
+
+	if _, err = BuildSADBREGISTERMsg(WithSAType(SADB_SATYPE_UNSPEC)); err == nil {
+		t.Error("Expected a SADB_REGISTER message without an SA type to be rejected")
+	}
+
+	if register, err := BuildSADBREGISTERMsg(WithSAType(SADB_SATYPE_AH)); err != nil || register.Msg.SAType != SADB_SATYPE_AH {
+		t.Errorf("Unexpected AH SADB_REGISTER message %+v: %v", register.Msg, err)
+	}
+
+	changed := cfg
+	changed.Options = []SAOption{WithSAType(SADB_SATYPE_ESP)}
+	if _, err = BuildSA(changed); err == nil {
+		t.Error("Expected changing the SA type of an SAConfig through its options to be rejected")
+	}
+}
+
+func TestBuildSAIPComp(t *testing.T) {
+	cfg := SAConfig{
+		SAType:      SADB_X_SATYPE_IPCOMP,
+		SPI:         0x4242,
+		Src:         netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst:         netip.MustParseAddrPort("10.0.0.2:0"),
+		CompressAlg: SADB_X_CALG_DEFLATE,
+	}
+
+	msg, err := BuildSA(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Msg.SAType != SADB_X_SATYPE_IPCOMP || msg.HasEncryptKey() || msg.HasAuthKey() {
+		t.Errorf("Unexpected IPComp message %+v", msg)
+	}
+	if msg.Extensions.SA.Encrypt != SADB_X_CALG_DEFLATE || msg.Extensions.SA.GetCPI() != 0x4242 {
+		t.Errorf("Unexpected IPComp SA %+v", msg.Extensions.SA)
+	}
+
+	bigCPI := cfg
+	bigCPI.SPI = 0x10000
+	if _, err = BuildSA(bigCPI); err == nil {
+		t.Error("Expected a CPI larger than 16 bits to be rejected")
+	}
+
+	withKey := cfg
+	withKey.EncryptAlg = SADB_X_EALG_AESCBC
+	withKey.EncryptKey = make([]byte, 16)
+	if _, err = BuildSA(withKey); err == nil {
+		t.Error("Expected an IPComp SA with an encryption key to be rejected")
+	}
+
+	withAuth := cfg
+	withAuth.Options = []SAOption{WithAuth(SADB_X_AALG_SHA2_256HMAC, make([]byte, 32))}
+	if _, err = BuildSA(withAuth); err == nil {
+		t.Error("Expected an IPComp SA with authentication given through an option to be rejected")
+	}
+
+	noCompression := cfg
+	noCompression.CompressAlg = SADB_X_CALG_NONE
+	if _, err = BuildSA(noCompression); err == nil {
+		t.Error("Expected an IPComp SA without compression algorithm to be rejected")
+	}
+
+	esp := cfg
+	esp.SAType = SADB_SATYPE_ESP
+	if _, err = BuildSA(esp); err == nil {
+		t.Error("Expected an ESP SA with a compression algorithm to be rejected")
+	}
+
+	getspi, err := BuildSADBGETSPI(1, Node{Addr: net.ParseIP("10.0.0.1")}, Node{Addr: net.ParseIP("10.0.0.2")}, WithSAType(SADB_X_SATYPE_IPCOMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getspi.Extensions.SPIRange.Min < 0x100 || getspi.Extensions.SPIRange.Max > 0xffff {
+		t.Errorf("Expected an SPI range within the CPI space but got %+v", getspi.Extensions.SPIRange)
+	}
+}
+
+func TestBuildSAAEAD(t *testing.T) {
+	cfg := SAConfig{
+		SPI:        0x2000,
+		Src:        netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst:        netip.MustParseAddrPort("10.0.0.2:0"),
+		EncryptAlg: SADB_X_EALG_AES_GCM_ICV16,
+		EncryptKey: bytes.Repeat([]byte{1}, 32),
+		Salt:       []byte{0xde, 0xad, 0xbe, 0xef},
+	}
+
+	msg, err := BuildSA(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Extensions.EncryptKey.Bits != 288 || !bytes.Equal(msg.Extensions.EncryptKeyBits[32:], cfg.Salt) {
+		t.Errorf("Expected the salt to be appended to the key but got %+v", msg.Extensions.EncryptKey)
+	}
+	if msg.Extensions.SA.Auth != SADB_AALG_NONE || msg.HasAuthKey() {
+		t.Errorf("Expected no authentication for an AEAD SA but got %+v", msg.Extensions.SA)
+	}
+	if len(cfg.EncryptKey) != 32 {
+		t.Error("Expected the caller's key to be left untouched")
+	}
+
+	badSalt := cfg
+	badSalt.Salt = []byte{1, 2, 3}
+	if _, err = BuildSA(badSalt); err == nil {
+		t.Error("Expected a 3 byte salt to be rejected for AES-GCM")
+	}
+
+	withAuth := cfg
+	withAuth.AuthAlg = SADB_X_AALG_SHA2_256HMAC
+	withAuth.AuthKey = make([]byte, 32)
+	if _, err = BuildSA(withAuth); err == nil {
+		t.Error("Expected an AEAD SA with an authentication algorithm to be rejected")
+	}
+
+	authOption := cfg
+	authOption.Options = []SAOption{WithAuth(SADB_X_AALG_SHA2_256HMAC, make([]byte, 32))}
+	if _, err = BuildSA(authOption); err == nil {
+		t.Error("Expected an AEAD SA with authentication given through an option to be rejected")
+	}
+
+	// The kernel doesn't send keys back, so drop them before decoding the SA like we would a dump
+	msg.Present.EncryptKey = false
+	msg.setMsgLen()
+	received := roundTripMsg(t, *msg)
+	info, err := received.DecodeSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.AEAD || info.ICVLen != 16 || info.EncryptAlg != SADB_X_EALG_AES_GCM_ICV16 || info.SPI != 0x2000 {
+		t.Errorf("Unexpected decoded AEAD SA %+v", info)
+	}
+	if info.Src != cfg.Src || info.Dst != cfg.Dst {
+		t.Errorf("Unexpected addresses in decoded SA %+v", info)
+	}
+}
+
+func TestSAFlagsAndReplayWindow(t *testing.T) {
+	src := Node{Addr: net.ParseIP("10.0.0.1")}
+	dst := Node{Addr: net.ParseIP("10.0.0.2")}
+
+	msg, err := BuildSADBADD(1, 1337, src, dst, make([]byte, 32), WithReplayWindow(32), WithSAFlags(SADB_SAFLAGS_NOECN|SADB_SAFLAGS_DECAP_DSCP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Extensions.SA.Replay != 32 || msg.Extensions.SA.Flags != SADB_SAFLAGS_NOECN|SADB_SAFLAGS_DECAP_DSCP {
+		t.Errorf("Unexpected SA %+v", msg.Extensions.SA)
+	}
+
+	received := roundTripMsg(t, *msg)
+	info, err := received.DecodeSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SAFlags{NoECN: true, DecapDSCP: true}
+	if info.Flags != expected || info.ReplayWindow != 32 {
+		t.Errorf("Expected flags %+v and replay window 32 but got %+v", expected, info)
+	}
+	if info.Flags.Bits() != msg.Extensions.SA.Flags {
+		t.Errorf("Expected flag bits %#x but got %#x instead", msg.Extensions.SA.Flags, info.Flags.Bits())
+	}
+
+	if _, err = BuildSADBADD(1, 1337, src, dst, make([]byte, 32), WithReplayWindow(256)); err == nil {
+		t.Error("Expected a replay window that doesn't fit in 8 bits to be rejected")
+	}
+	if _, err = BuildSADBADD(1, 1337, src, dst, make([]byte, 32), WithSAFlags(0x100)); err == nil {
+		t.Error("Expected unknown SA flags to be rejected")
+	}
+	if _, err = BuildSADBGETSPI(1, src, dst, WithReplayWindow(32)); err == nil {
+		t.Error("Expected a replay window on a message without SA to be rejected")
+	}
+
+	if _, err = BuildSA(SAConfig{
+		Src:        netip.MustParseAddrPort("10.0.0.1:0"),
+		Dst:        netip.MustParseAddrPort("10.0.0.2:0"),
+		EncryptAlg: SADB_X_EALG_AESCBC,
+		EncryptKey: make([]byte, 16),
+		Flags:      SADB_SAFLAGS_PFS | 0x2,
+	}); err == nil {
+		t.Error("Expected unknown SA flags to be rejected by BuildSA")
+	}
+}
@@ -74,6 +74,8 @@ type SADBComb struct {
 	Flags           uint16
 	AuthMinBits     uint16
 	AuthMaxBits     uint16
+	EncryptMinBits  uint16
+	EncryptMaxBits  uint16
 	Reserved        uint32
 	SoftAllocations uint32
 	HardAllocations uint32
@@ -120,3 +122,62 @@ type SADBSPIRange struct {
 	Max      uint32
 	Reserved uint32
 }
+
+// SADBXSA2 holds a sadb_x_sa2 extension for a PF_KEY message.
+type SADBXSA2 struct {
+	Len       uint16
+	ExtType   uint16
+	Mode      uint8
+	Reserved1 uint8
+	Reserved2 uint16
+	Sequence  uint32
+	ReqID     uint32
+}
+
+// SADBXNATTType holds a sadb_x_nat_t_type extension for a PF_KEY message.
+type SADBXNATTType struct {
+	Len      uint16
+	ExtType  uint16
+	Type     uint8
+	Reserved [3]uint8
+}
+
+// SADBXNATTPort holds a sadb_x_nat_t_port extension for a PF_KEY message.
+// Port is stored in network order, use GetPort to retrieve it in machine order.
+type SADBXNATTPort struct {
+	Len      uint16
+	ExtType  uint16
+	Port     uint16
+	Reserved uint16
+}
+
+// SADBXSecCtx holds a sadb_x_sec_ctx extension for a PF_KEY message.
+// It is followed by CtxLen bytes of security context, padded to a 64-bit boundary.
+type SADBXSecCtx struct {
+	Len     uint16
+	ExtType uint16
+	CtxDOI  uint8
+	CtxAlg  uint8
+	CtxLen  uint16
+}
+
+// SADBXIPSecRequest holds a sadb_x_ipsecrequest, any number of which can follow a sadb_x_policy extension.
+// When the request is in tunnel mode it's followed by the sockaddr structures for both ends of the tunnel.
+// Len is expressed in bytes and includes the size of those sockaddr structures.
+type SADBXIPSecRequest struct {
+	Len       uint16
+	Proto     uint16
+	Mode      uint8
+	Level     uint8
+	Reserved1 uint16
+	ReqID     uint32
+	Reserved2 uint32
+}
+
+// SADBXKMAddress holds a sadb_x_kmaddress extension for a PF_KEY message.
+// It's followed by the sockaddr structures for the local and remote addresses of the key manager.
+type SADBXKMAddress struct {
+	Len      uint16
+	ExtType  uint16
+	Reserved uint32
+}
@@ -0,0 +1,149 @@
+package pfkey
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSelectorRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		src Selector
+		dst Selector
+	}{
+		{
+			src: Selector{Prefix: netip.MustParsePrefix("10.0.0.0/24"), Port: 443, Proto: unix.IPPROTO_TCP},
+			dst: Selector{Prefix: netip.MustParsePrefix("10.1.0.0/16"), Proto: unix.IPPROTO_TCP},
+		},
+		{
+			src: Selector{Prefix: netip.MustParsePrefix("2001:db8::/64"), Proto: IPSEC_ULPROTO_ANY},
+			dst: Selector{Prefix: netip.MustParsePrefix("2001:db8:1::/48"), Port: 53, Proto: unix.IPPROTO_UDP},
+		},
+	} {
+		msg := Msg{Msg: SADBMsg{Version: PF_KEY_V2, Type: SADB_X_SPDADD}}
+		msg.SetSelectorSrc(tc.src)
+		msg.SetSelectorDst(tc.dst)
+		msg.SetXPolicy(SADBXPolicy{Type: IPSEC_POLICY_NONE, Dir: IPSEC_DIR_OUTBOUND}, nil)
+
+		if msg.Extensions.AddressSrc.PrefixLen != uint8(tc.src.Prefix.Bits()) || msg.Extensions.AddressSrc.Proto != tc.src.Proto {
+			t.Errorf("Unexpected source address extension %+v for %+v", msg.Extensions.AddressSrc, tc.src)
+		}
+
+		received := roundTripMsg(t, msg)
+
+		src, err := received.SelectorSrc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src != tc.src {
+			t.Errorf("Expected source selector %+v but got %+v instead", tc.src, src)
+		}
+
+		dst, err := received.SelectorDst()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dst != tc.dst {
+			t.Errorf("Expected destination selector %+v but got %+v instead", tc.dst, dst)
+		}
+	}
+}
+
+func TestSelectorFromNode(t *testing.T) {
+	msg := Msg{}
+	msg.SetAddressSrc(Node{Addr: net.IPv4(1, 2, 3, 4), Port: networkOrder16(500, nativeEndian)})
+
+	src, err := msg.SelectorSrc()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Selector{Prefix: netip.MustParsePrefix("1.2.3.4/32"), Port: 500}
+	if src != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, src)
+	}
+
+	if _, err = msg.SelectorDst(); err != ErrExtensionNotPresent {
+		t.Errorf("Expected %v but got %v instead", ErrExtensionNotPresent, err)
+	}
+}
+
+func TestReceiveSelector(t *testing.T) {
+	// SADB_X_SPDADD with 192.168.0.0/16 (TCP) as its source and 10.0.0.1/32 (any protocol) as its destination
+	received := []byte{
+		2, 14, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 5, 0, 6, 16, 0, 0, 2, 0, 0, 0, 192, 168, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 6, 0, 255, 32, 0, 0, 2, 0, 0, 0, 10, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	msg, err := ParseMsg(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := msg.SelectorSrc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Selector{Prefix: netip.MustParsePrefix("192.168.0.0/16"), Proto: unix.IPPROTO_TCP}); src != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, src)
+	}
+
+	dst, err := msg.SelectorDst()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Selector{Prefix: netip.MustParsePrefix("10.0.0.1/32"), Proto: IPSEC_ULPROTO_ANY}); dst != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, dst)
+	}
+}
+
+func TestReceiveInvalidPrefixLen(t *testing.T) {
+	// SADB_X_SPDADD whose IPv4 source claims a 40 bit prefix
+	received := []byte{
+		2, 14, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 5, 0, 6, 40, 0, 0, 2, 0, 0, 0, 192, 168, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 6, 0, 255, 32, 0, 0, 2, 0, 0, 0, 10, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	msg, err := ParseMsg(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Extensions.AddressSrc.PrefixLen != 40 || msg.Extensions.AddressSrc.Proto != unix.IPPROTO_TCP {
+		t.Errorf("Expected the address fields to be kept as received but got %+v", msg.Extensions.AddressSrc)
+	}
+
+	if _, err = msg.SelectorSrc(); err == nil {
+		t.Error("Expected an error reading a selector with an invalid prefix length")
+	}
+
+	if _, err = msg.SelectorDst(); err != nil {
+		t.Errorf("Unexpected error reading a valid selector: %v", err)
+	}
+}
+
+func TestReceiveProxyAndNATTOAFields(t *testing.T) {
+	// SADB_ADD with 192.168.1.0/24 (TCP) as its proxy address and 10.0.0.1/32 (UDP) as its NAT-T original address
+	received := []byte{
+		2, 3, 0, 3, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 7, 0, 6, 24, 0, 0, 2, 0, 0, 0, 192, 168, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 23, 0, 17, 32, 0, 0, 2, 0, 0, 0, 10, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	msg, err := ParseMsg(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if proxy := msg.Extensions.AddressProxy; !msg.HasAddressProxy() || proxy.PrefixLen != 24 || proxy.Proto != unix.IPPROTO_TCP {
+		t.Errorf("Expected the proxy address fields to be kept as received but got %+v", proxy)
+	}
+
+	if oa := msg.Extensions.NATTOA; !msg.HasNATTOA() || oa.PrefixLen != 32 || oa.Proto != unix.IPPROTO_UDP {
+		t.Errorf("Expected the NAT-T original address fields to be kept as received but got %+v", oa)
+	}
+}
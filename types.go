@@ -1,14 +1,17 @@
 package pfkey
 
 import (
-	"bytes"
+	"encoding/binary"
 	"io"
 	"net"
+	"net/netip"
 )
 
-// msgBuffer is a buffer that allows us to write arbitrary data structures into a bytes.Buffer
+// msgBuffer is a buffer that allows us to write arbitrary data structures into a slice of bytes
 type msgBuffer struct {
-	buf bytes.Buffer
+	buf []byte
+	// order is the byte order used to encode structures, nativeEndian if nil.
+	order binary.ByteOrder
 }
 
 // PFKEY represents the connection to a PF_KEY socket.
@@ -27,9 +30,11 @@ type sadbExtensions struct {
 	LifetimeSoft      SADBLifetime
 	LifetimeHard      SADBLifetime
 	AddressSrc        SADBAddress
-	SockAddrSrc       sockAddrIn
+	SockAddrSrc       sockAddr
 	AddressDst        SADBAddress
-	SockAddrDst       sockAddrIn
+	SockAddrDst       sockAddr
+	AddressProxy      SADBAddress
+	SockAddrProxy     sockAddr
 	Proposal          SADBProp
 	ProposalCombs     []SADBComb
 	AuthKey           SADBKey
@@ -40,6 +45,18 @@ type sadbExtensions struct {
 	EncryptAlgorithms []SADBAlg
 	SPIRange          SADBSPIRange
 	XPolicy           SADBXPolicy
+	XPolicyRequests   []IPSecRequest
+	SA2               SADBXSA2
+	NATTType          SADBXNATTType
+	NATTSport         SADBXNATTPort
+	NATTDport         SADBXNATTPort
+	NATTOA            SADBAddress
+	SockAddrNATTOA    sockAddr
+	SecCtx            SADBXSecCtx
+	SecCtxBits        []byte
+	KMAddress         SADBXKMAddress
+	SockAddrKMLocal   sockAddr
+	SockAddrKMRemote  sockAddr
 }
 
 // sadbExtensionsChecklist holds a checklist to mark if a given SADBMsg includes certain extensions or not.
@@ -50,6 +67,7 @@ type sadbExtensionsChecklist struct {
 	LifetimeHard      bool
 	AddressSrc        bool
 	AddressDst        bool
+	AddressProxy      bool
 	Proposal          bool
 	AuthKey           bool
 	EncryptKey        bool
@@ -57,6 +75,13 @@ type sadbExtensionsChecklist struct {
 	EncryptAlgorithms bool
 	SPIRange          bool
 	XPolicy           bool
+	SA2               bool
+	NATTType          bool
+	NATTSport         bool
+	NATTDport         bool
+	NATTOA            bool
+	SecCtx            bool
+	KMAddress         bool
 }
 
 // Msg holds a full message that can be sent/received through a PF_KEY socket.
@@ -73,16 +98,46 @@ type Registration struct {
 	EncrAlgorithms []SADBAlg
 }
 
+// IPSecRequest holds a sadb_x_ipsecrequest along with the tunnel endpoints that may follow it.
+type IPSecRequest struct {
+	Request     SADBXIPSecRequest
+	SockAddrSrc sockAddr
+	SockAddrDst sockAddr
+}
+
 // Node represents one of the two ends of an SA.
 type Node struct {
 	Addr net.IP
 	Port uint16
 }
 
-// AddrAsArray returns the Address of this node as a 4-byte array
-// TODO: This obviously only works for IPv4
+// AddrAsArray returns the Address of this node as a 4-byte array.
+// It returns the zero address if this isn't an IPv4 node, see AddrAsArray16 for IPv6.
 func (n *Node) AddrAsArray() [4]byte {
-	return [4]byte{n.Addr[12], n.Addr[13], n.Addr[14], n.Addr[15]}
+	var a [4]byte
+	copy(a[:], n.Addr.To4())
+	return a
+}
+
+// AddrAsArray16 returns the Address of this node as a 16-byte array.
+// IPv4 addresses are returned in their IPv4-mapped IPv6 form.
+func (n *Node) AddrAsArray16() [16]byte {
+	var a [16]byte
+	copy(a[:], n.Addr.To16())
+	return a
+}
+
+// sockAddr is implemented by the sockaddr structures that can follow a sadb_address extension.
+type sockAddr interface {
+	wireStruct
+	// BuildNode generates a Node object pointing to the same host as this sockaddr
+	BuildNode() (Node, error)
+	// family returns the address family (AF_INET or AF_INET6) of this sockaddr
+	family() uint16
+	// addrPort returns the address and port (in machine order) of this sockaddr
+	addrPort() netip.AddrPort
+	// prefixLen returns the length in bits of the addresses in this family
+	prefixLen() uint8
 }
 
 type sockAddrIn struct {
@@ -91,3 +146,11 @@ type sockAddrIn struct {
 	SinAddr   [4]byte
 	SinZero   [8]byte //padding
 }
+
+type sockAddrIn6 struct {
+	Sin6Family   int16
+	Sin6Port     uint16
+	Sin6Flowinfo uint32
+	Sin6Addr     [16]byte
+	Sin6ScopeID  uint32
+}
@@ -1,108 +1,257 @@
 package pfkey
 
 import (
-	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
+	"io"
 
 	"github.com/FranGM/simplelog"
 	"golang.org/x/sys/unix"
 )
 
-func (s *SADBMsg) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
+// ParseMsg parses a full PF_KEY message (base message plus all its extensions) from b.
+func ParseMsg(b []byte) (Msg, error) {
+	return parseMsg(b, nativeEndian)
 }
 
-func readSADBAlg(buf *bytes.Buffer) (SADBAlg, error) {
-	var newSADBAlg SADBAlg
-	err := newSADBAlg.readFromBuffer(buf)
-	return newSADBAlg, err
-}
+// parseMsg parses a PF_KEY message whose structures were encoded using the given byte order.
+func parseMsg(b []byte, order binary.ByteOrder) (Msg, error) {
+	f, err := newFrame(b, order)
+	if err != nil {
+		return Msg{}, err
+	}
 
-func readSADBSupported(buf *bytes.Buffer) (SADBSupported, error) {
-	var newSADBSupported SADBSupported
-	err := newSADBSupported.readFromBuffer(buf)
-	return newSADBSupported, err
+	return f.Msg()
 }
 
-func readSADBMsg(buf *bytes.Buffer) (SADBMsg, error) {
-	var newMsg SADBMsg
-	err := newMsg.readFromBuffer(buf)
-	return newMsg, err
-}
+// parseExtension parses a single extension of type extType, stored in ext, and adds it to this PFKEYMsg.
+func (p *Msg) parseExtension(extType uint16, ext []byte, order binary.ByteOrder) error {
+	switch extType {
+	case SADB_EXT_SA:
+		var newSA SADBSA
+		err := newSA.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetSA(newSA)
+	case SADB_EXT_LIFETIME_HARD:
+		var newLT SADBLifetime
+		err := newLT.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetLifetimeHard(newLT)
+	case SADB_EXT_LIFETIME_SOFT:
+		var newLT SADBLifetime
+		err := newLT.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetLifetimeSoft(newLT)
+	case SADB_EXT_LIFETIME_CURRENT:
+		var newLT SADBLifetime
+		err := newLT.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetLifetimeCurrent(newLT)
+	case SADB_EXT_ADDRESS_SRC:
+		address, sAddr, err := parseAddress(ext, order)
+		if err != nil {
+			return err
+		}
+		p.Extensions.AddressSrc, p.Extensions.SockAddrSrc = address, sAddr
+		p.Present.AddressSrc = true
+	case SADB_EXT_ADDRESS_DST:
+		address, sAddr, err := parseAddress(ext, order)
+		if err != nil {
+			return err
+		}
+		p.Extensions.AddressDst, p.Extensions.SockAddrDst = address, sAddr
+		p.Present.AddressDst = true
+	case SADB_EXT_ADDRESS_PROXY:
+		address, sAddr, err := parseAddress(ext, order)
+		if err != nil {
+			return err
+		}
+		p.Extensions.AddressProxy, p.Extensions.SockAddrProxy = address, sAddr
+		p.Present.AddressProxy = true
+	case SADB_EXT_SUPPORTED_AUTH:
+		algs, err := parseAlgorithms(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetAuthAlgorithms(algs)
+	case SADB_EXT_SUPPORTED_ENCRYPT:
+		algs, err := parseAlgorithms(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetEncryptAlgorithms(algs)
+	case SADB_EXT_PROPOSAL:
+		var prop SADBProp
+		err := prop.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
 
-// readNodeFromBuffer reads a SADBAddress and a sockaddr_in struct and returns a Node
-func readNodeFromBuffer(buf *bytes.Buffer) (Node, error) {
-	var node Node
-	var address SADBAddress
-	var sckaddr sockAddrIn
+		combs, err := parseProposals(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetProposal(prop.Replay, combs)
+	case SADB_EXT_KEY_AUTH:
+		extensionNotImplemented(extType, ext)
+	case SADB_EXT_KEY_ENCRYPT:
+		extensionNotImplemented(extType, ext)
+	case SADB_X_EXT_SA2:
+		var newSA2 SADBXSA2
+		err := newSA2.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetSA2(newSA2)
+	case SADB_X_EXT_NAT_T_TYPE:
+		var newType SADBXNATTType
+		err := newType.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetNATTType(newType.Type)
+	case SADB_X_EXT_NAT_T_SPORT:
+		var newPort SADBXNATTPort
+		err := newPort.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetNATTSport(newPort.GetPort())
+	case SADB_X_EXT_NAT_T_DPORT:
+		var newPort SADBXNATTPort
+		err := newPort.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetNATTDport(newPort.GetPort())
+	case SADB_X_EXT_NAT_T_OA:
+		address, sAddr, err := parseAddress(ext, order)
+		if err != nil {
+			return err
+		}
+		p.Extensions.NATTOA, p.Extensions.SockAddrNATTOA = address, sAddr
+		p.Present.NATTOA = true
+	case SADB_X_EXT_SEC_CTX:
+		secCtx, ctx, err := parseSecCtx(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetSecCtx(secCtx.CtxDOI, secCtx.CtxAlg, ctx)
+	case SADB_X_EXT_POLICY:
+		var policy SADBXPolicy
+		err := policy.unmarshal(ext, order)
+		if err != nil {
+			return err
+		}
 
-	err := address.readFromBuffer(buf)
-	if err != nil {
-		return node, err
+		requests, err := parseIPSecRequests(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetXPolicy(policy, requests)
+	case SADB_X_EXT_KMADDRESS:
+		local, remote, err := parseKMAddress(ext, order)
+		if err != nil {
+			return err
+		}
+		p.SetKMAddress(local, remote)
+	default:
+		return fmt.Errorf("received unexpected extension when parsing message: %d", extType)
 	}
 
-	// TODO: We need to look at the family here and figure out what kind of socket data structure we need to use.
-	// For now we'll make do with sockaddr_in
-	err = sckaddr.readFromBuffer(buf)
+	return nil
+}
+
+// parseNode parses a sadb_address extension followed by a sockaddr_in or sockaddr_in6 struct and returns a Node
+func parseNode(ext []byte, order binary.ByteOrder) (Node, error) {
+	_, sAddr, err := parseAddress(ext, order)
 	if err != nil {
-		return node, err
+		return Node{}, err
 	}
 
-	return sckaddr.BuildNode(), nil
+	return sAddr.BuildNode()
 }
 
-func readSADBExt(buf *bytes.Buffer) (SADBExt, error) {
-	newSADBExt := new(SADBExt)
+// parseAddress parses a sadb_address extension along with the sockaddr that follows it, keeping their fields as received.
+// The prefix length and protocol are only checked when they're read through SelectorSrc or SelectorDst.
+func parseAddress(ext []byte, order binary.ByteOrder) (SADBAddress, sockAddr, error) {
+	var address SADBAddress
 
-	// We need to read sadb_ext without advancing the buffer
-	// TODO: Need to actually check we have enough bytes in the buffer
-	// TODO sadb_ext size shouldn't be hardcoded like this
-	const ExtSize = 4
-	err := binary.Read(bytes.NewReader(buf.Bytes()[:ExtSize]), binary.LittleEndian, newSADBExt)
-	return *newSADBExt, err
-}
+	err := address.unmarshal(ext, order)
+	if err != nil {
+		return address, nil, err
+	}
 
-func readSADBProp(buf *bytes.Buffer) (SADBProp, error) {
-	var newSADBProp SADBProp
-	err := newSADBProp.readFromBuffer(buf)
-	return newSADBProp, err
+	sAddr, err := parseSockAddr(ext[SADBADDRESS_LEN*WORD_SIZE:], order)
+	return address, sAddr, err
 }
 
-func readSADBComb(buf *bytes.Buffer) (SADBComb, error) {
-	var newSADBComb SADBComb
-	err := newSADBComb.readFromBuffer(buf)
+// parseSockAddr parses the sockaddr structure at the start of b, using its family to tell
+// whether it's a sockaddr_in or a sockaddr_in6.
+func parseSockAddr(b []byte, order binary.ByteOrder) (sockAddr, error) {
+	if len(b) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
 
-	return newSADBComb, err
+	switch family := order.Uint16(b); family {
+	case unix.AF_INET:
+		var sAddr sockAddrIn
+		err := sAddr.unmarshal(b, order)
+		return sAddr, err
+	case unix.AF_INET6:
+		var sAddr sockAddrIn6
+		err := sAddr.unmarshal(b, order)
+		return sAddr, err
+	default:
+		return nil, fmt.Errorf("address family %d not implemented in sockaddr", family)
+	}
 }
 
-func readProposals(buf *bytes.Buffer, propLen uint16) ([]SADBComb, error) {
-	combs := make([]SADBComb, 0)
-	for i := 1; i < int(propLen); i++ {
-		comb, err := readSADBComb(buf)
+// parseProposals parses all the sadb_comb structures following the sadb_prop header in ext.
+func parseProposals(ext []byte, order binary.ByteOrder) ([]SADBComb, error) {
+	var combs []SADBComb
 
+	b := ext[SADBPROP_LEN*WORD_SIZE:]
+	if len(b)%(SADBCOMB_LEN*WORD_SIZE) != 0 {
+		return combs, fmt.Errorf("invalid sadb_prop length: %d", len(ext)/WORD_SIZE)
+	}
+
+	for len(b) > 0 {
+		var comb SADBComb
+		err := comb.unmarshal(b, order)
 		if err != nil {
 			return combs, err
 		}
 
 		simplelog.Debug.Printf("Comb received: %+v", comb)
 		combs = append(combs, comb)
+		b = b[SADBCOMB_LEN*WORD_SIZE:]
 	}
 
 	return combs, nil
 }
 
-func readAlgorithms(buf *bytes.Buffer) ([]SADBAlg, error) {
-	algs := make([]SADBAlg, 0)
+// parseAlgorithms parses a sadb_supported extension and returns all the sadb_alg structures in it.
+func parseAlgorithms(ext []byte, order binary.ByteOrder) ([]SADBAlg, error) {
+	algs := make([]SADBAlg, 0, len(ext)/WORD_SIZE)
 
-	supported, err := readSADBSupported(buf)
+	var supported SADBSupported
+	err := supported.unmarshal(ext, order)
 	if err != nil {
 		return algs, err
 	}
 
-	for i := 1; i < int(supported.Len); i++ {
-		alg, err := readSADBAlg(buf)
+	for b := ext[SADBSUPPORTED_LEN*WORD_SIZE:]; len(b) > 0; b = b[SADBALG_LEN*WORD_SIZE:] {
+		var alg SADBAlg
+		err := alg.unmarshal(b, order)
 		if err != nil {
 			return algs, err
 		}
@@ -112,72 +261,54 @@ func readAlgorithms(buf *bytes.Buffer) ([]SADBAlg, error) {
 	return algs, nil
 }
 
-func (s *SADBAlg) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
-}
-
-func (s *SADBXPolicy) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
-}
-
-func (s *SADBSupported) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
-}
-
-func (s *SADBLifetime) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
-}
+// parseSecCtx parses a sadb_x_sec_ctx extension, returning it along with its security context.
+func parseSecCtx(ext []byte, order binary.ByteOrder) (SADBXSecCtx, []byte, error) {
+	var secCtx SADBXSecCtx
+	err := secCtx.unmarshal(ext, order)
+	if err != nil {
+		return secCtx, nil, err
+	}
 
-func (s *SADBAddress) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
-}
+	data := ext[SADBXSECCTX_LEN*WORD_SIZE:]
+	if int(secCtx.CtxLen) > len(data) {
+		return secCtx, nil, fmt.Errorf("invalid security context length %d in extension of length %d", secCtx.CtxLen, secCtx.Len)
+	}
 
-func (s *SADBProp) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
-}
+	ctx := make([]byte, secCtx.CtxLen)
+	copy(ctx, data)
 
-func (s *SADBComb) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
+	return secCtx, ctx, nil
 }
 
-func (s *sockAddrIn) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
-}
+// parseKMAddress parses a sadb_x_kmaddress extension and returns the local and remote addresses in it.
+func parseKMAddress(ext []byte, order binary.ByteOrder) (Node, Node, error) {
+	var kmAddress SADBXKMAddress
 
-func (s *Node) readFromBuffer(buf *bytes.Buffer) error {
-	sockAddr := new(sockAddrIn)
-	err := binary.Read(buf, binary.LittleEndian, sockAddr)
+	err := kmAddress.unmarshal(ext, order)
 	if err != nil {
-		return err
+		return Node{}, Node{}, err
 	}
 
-	simplelog.Debug.Printf("%+v", sockAddr)
-	// TODO: Also support IPv6
-	if sockAddr.SinFamily != unix.AF_INET {
-		return errors.New("Unsupported family in sockaddr")
+	b := ext[SADBXKMADDRESS_LEN*WORD_SIZE:]
+	local, err := parseSockAddr(b, order)
+	if err != nil {
+		return Node{}, Node{}, err
 	}
 
-	node := sockAddr.BuildNode()
-	s.Port = node.Port
-	s.Addr = node.Addr
+	remote, err := parseSockAddr(b[local.wireSize():], order)
+	if err != nil {
+		return Node{}, Node{}, err
+	}
 
-	return nil
-}
+	localNode, err := local.BuildNode()
+	if err != nil {
+		return Node{}, Node{}, err
+	}
 
-func (s *SADBKey) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
-}
+	remoteNode, err := remote.BuildNode()
+	if err != nil {
+		return Node{}, Node{}, err
+	}
 
-func (s *SADBSA) readFromBuffer(buf *bytes.Buffer) error {
-	err := binary.Read(buf, binary.LittleEndian, s)
-	return err
+	return localNode, remoteNode, nil
 }
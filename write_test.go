@@ -11,6 +11,9 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// sadbDumpResponse is a single-entry reply to a SADB_DUMP message, as sent by the kernel.
+var sadbDumpResponse = []byte{2, 10, 0, 3, 32, 0, 0, 0, 0, 0, 0, 0, 103, 10, 0, 0, 2, 0, 1, 0, 0, 30, 198, 170, 0, 1, 0, 12, 0, 0, 0, 0, 4, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 2, 0, 11, 0, 0, 0, 192, 2, 0, 0, 0, 0, 0, 0, 227, 179, 15, 89, 0, 0, 0, 0, 228, 179, 15, 89, 0, 0, 0, 0, 3, 0, 5, 0, 0, 32, 0, 0, 2, 0, 0, 0, 10, 0, 2, 7, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 6, 0, 0, 32, 0, 0, 2, 0, 0, 0, 10, 0, 2, 6, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 7, 0, 255, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5, 0, 9, 0, 0, 1, 0, 0, 40, 141, 178, 141, 242, 74, 142, 67, 237, 231, 145, 81, 148, 10, 249, 253, 77, 164, 119, 141, 106, 73, 193, 49, 35, 84, 139, 157, 95, 216, 244, 48, 2, 0, 19, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
 // TODO: This file is by no means great, let's try to reduce at least some of the code duplication
 //    so we can add more tests with more thorough checking.
 
@@ -126,7 +129,7 @@ func TestReceiveSADBDump(t *testing.T) {
 
 		// TODO: Compare buf to a well formed request?
 
-		response := []byte{2, 10, 0, 3, 32, 0, 0, 0, 0, 0, 0, 0, 103, 10, 0, 0, 2, 0, 1, 0, 0, 30, 198, 170, 0, 1, 0, 12, 0, 0, 0, 0, 4, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 2, 0, 11, 0, 0, 0, 192, 2, 0, 0, 0, 0, 0, 0, 227, 179, 15, 89, 0, 0, 0, 0, 228, 179, 15, 89, 0, 0, 0, 0, 3, 0, 5, 0, 0, 32, 0, 0, 2, 0, 0, 0, 10, 0, 2, 7, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 6, 0, 0, 32, 0, 0, 2, 0, 0, 0, 10, 0, 2, 6, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 7, 0, 255, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5, 0, 9, 0, 0, 1, 0, 0, 40, 141, 178, 141, 242, 74, 142, 67, 237, 231, 145, 81, 148, 10, 249, 253, 77, 164, 119, 141, 106, 73, 193, 49, 35, 84, 139, 157, 95, 216, 244, 48, 2, 0, 19, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		response := sadbDumpResponse
 
 		server.Write(response)
 	}()
@@ -162,6 +165,8 @@ func TestReceiveSADBDump(t *testing.T) {
 			LifetimeHard:    true,
 			AddressSrc:      true,
 			AddressDst:      true,
+			AddressProxy:    true,
+			SA2:             true,
 		},
 		Extensions: sadbExtensions{
 			SA: SADBSA{
@@ -206,6 +211,20 @@ func TestReceiveSADBDump(t *testing.T) {
 				SinFamily: unix.AF_INET,
 				SinAddr:   [4]byte{10, 0, 2, 6},
 			},
+			// The kernel sends an empty proxy address with a prefix length of 0 for any protocol
+			AddressProxy: SADBAddress{
+				Len:     3,
+				ExtType: 7,
+				Proto:   IPSEC_ULPROTO_ANY,
+			},
+			SockAddrProxy: sockAddrIn{
+				SinFamily: unix.AF_INET,
+			},
+			SA2: SADBXSA2{
+				Len:     2,
+				ExtType: 19,
+				Mode:    IPSEC_MODE_TRANSPORT,
+			},
 		},
 	}
 
@@ -241,8 +260,11 @@ func TestBuildDump(t *testing.T) {
 		},
 	}
 
-	m := BuildSADBDUMPMsg()
-	err := compareMessages(expected, m)
+	m, err := BuildSADBDUMPMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = compareMessages(expected, m)
 	if err != nil {
 		t.Errorf("Error building SADB_DUMP message: %s", err)
 	}
@@ -305,3 +327,91 @@ func TestReceiveEmptyDump(t *testing.T) {
 		}
 	*/
 }
+
+// roundTripMsg sends msg through a PF_KEY connection and parses the bytes
+// written on the other side back into a Msg.
+func roundTripMsg(t *testing.T, msg Msg) Msg {
+	server, client := net.Pipe()
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		defer server.Close()
+
+		buf := make([]byte, 4096)
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ch <- buf[:n]
+	}()
+
+	p := PFKEY{socket: client}
+	if err := p.SendMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	sent := <-ch
+
+	reader, writer := net.Pipe()
+	go func() {
+		writer.Write(sent)
+		writer.Close()
+	}()
+
+	p = PFKEY{socket: reader}
+	received, err := p.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return received
+}
+
+func TestAddressProxy(t *testing.T) {
+	msg := BuildSADBFLUSH()
+	msg.SetAddressSrc(Node{Addr: net.IPv4(1, 2, 3, 4)})
+	msg.SetAddressDst(Node{Addr: net.IPv4(5, 6, 7, 8)})
+	msg.SetAddressProxy(Node{Addr: net.IPv4(9, 10, 11, 12)})
+
+	received := roundTripMsg(t, msg)
+
+	if !received.HasAddressProxy() {
+		t.Fatal("Expected AddressProxy extension to be present")
+	}
+
+	expected := SADBAddress{Len: 3, ExtType: SADB_EXT_ADDRESS_PROXY, PrefixLen: 32}
+	if received.Extensions.AddressProxy != expected {
+		t.Errorf("Expected %+v but got %+v instead", expected, received.Extensions.AddressProxy)
+	}
+
+	if received.Extensions.SockAddrProxy.(sockAddrIn).SinAddr != [4]byte{9, 10, 11, 12} {
+		t.Errorf("Unexpected proxy address %+v", received.Extensions.SockAddrProxy)
+	}
+
+	if received.Msg.Len != 11 {
+		t.Errorf("Expected message length 11 but got %d instead", received.Msg.Len)
+	}
+}
+
+func TestSendingSADBREGISTERReply(t *testing.T) {
+	expected := loadFixtures(t)["registration_1"]
+
+	registration := expectedMessages["registration_1"]
+	msg := Msg{Msg: registration.Msg}
+	msg.SetAuthAlgorithms(registration.Extensions.AuthAlgorithms)
+	msg.SetEncryptAlgorithms(registration.Extensions.EncryptAlgorithms)
+
+	ch := make(chan error)
+	server, client := net.Pipe()
+	go receiveAndCheckOutput(server, expected, ch)
+
+	p := PFKEY{socket: client}
+
+	err := p.SendMsg(msg)
+	if err != nil {
+		t.Error(err)
+	}
+	for e := range ch {
+		t.Error(e)
+	}
+}